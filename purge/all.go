@@ -0,0 +1,88 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdAll = &cobra.Command{
+	Use:   "all",
+	Short: "Purge services, containers, images, volumes and networks",
+	Long: "Purge services, containers, images, volumes and networks in dependency order. " +
+		"Each resource type has its own filters, a type without filters is skipped",
+	RunE: RunCmdAll,
+}
+
+var (
+	// filters of each resource type for the all command
+	allSvcFilter []string
+	allCtnFilter []string
+	allImgFilter []string
+	allVolFilter []string
+	allNetFilter []string
+)
+
+// purgeStep removes one type of resource as part of the all command
+type purgeStep struct {
+	filters []Filter
+	remove  func(ctx context.Context, filters ...Filter) (Summary, error)
+}
+
+// allSteps parses the filters of every resource type of the all command, in
+// dependency order: services own containers, containers hold images,
+// volumes and networks. Types without filters are left out.
+func allSteps() (steps []purgeStep, err error) {
+	for _, step := range []struct {
+		filters []string
+		remove  func(ctx context.Context, filters ...Filter) (Summary, error)
+	}{
+		{allSvcFilter, RemoveServices},
+		{allCtnFilter, RemoveContainers},
+		{allImgFilter, RemoveImages},
+		{allVolFilter, RemoveVolumes},
+		{allNetFilter, RemoveNetworks},
+	} {
+		if len(step.filters) == 0 {
+			continue
+		}
+		filters, err := parseFilters(step.filters)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, purgeStep{filters, step.remove})
+	}
+	return
+}
+
+// purgeSteps runs steps in order. Later steps depend on earlier ones, so it
+// stops at the first error, returning the summaries of the steps done, and
+// the one of the step canceled by ctx.
+func purgeSteps(ctx context.Context, steps []purgeStep) (summaries []Summary, err error) {
+	for _, step := range steps {
+		summary, err := step.remove(ctx, step.filters...)
+		if err != nil {
+			if ctx.Err() != nil {
+				summaries = append(summaries, summary)
+			}
+			return summaries, fmt.Errorf("purging %s: %s", summary.Resource, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return
+}
+
+func RunCmdAll(cmd *cobra.Command, args []string) error {
+	if len(filter) > 0 || where != "" {
+		return errors.New("-f and --where are ambiguous for all, use --service, --container, --image, --volume or --network")
+	}
+	steps, err := allSteps()
+	if err != nil {
+		return err
+	}
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return purgeSteps(ctx, steps)
+	})
+}
@@ -0,0 +1,63 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAllSteps(t *testing.T) {
+	defer func(svc, ctn, img, vol, net []string) {
+		allSvcFilter, allCtnFilter, allImgFilter, allVolFilter, allNetFilter = svc, ctn, img, vol, net
+	}(allSvcFilter, allCtnFilter, allImgFilter, allVolFilter, allNetFilter)
+
+	// the volumes have no filters and are skipped
+	allSvcFilter, allCtnFilter, allImgFilter = []string{"name=svc"}, []string{"name=ctn"}, []string{"tag=img"}
+	allVolFilter, allNetFilter = nil, []string{"name=net"}
+	steps, err := allSteps()
+	if err != nil {
+		t.Fatalf("all steps error: %s", err)
+	}
+	var order []string
+	for _, step := range steps {
+		order = append(order, step.filters[0].Source)
+	}
+	if strings.Join(order, " ") != "name=svc name=ctn tag=img name=net" {
+		t.Errorf("wrong order of steps: %v", order)
+	}
+
+	allImgFilter = []string{"tag"}
+	if _, err = allSteps(); err == nil {
+		t.Error("an invalid filter should fail before any step")
+	}
+}
+
+func TestPurgeSteps(t *testing.T) {
+	var done []string
+	step := func(resource string, err error) purgeStep {
+		return purgeStep{remove: func(ctx context.Context, filters ...Filter) (Summary, error) {
+			done = append(done, resource)
+			return Summary{Resource: resource, Removed: 1}, err
+		}}
+	}
+	summaries, err := purgeSteps(context.Background(), []purgeStep{
+		step("service", nil), step("container", nil), step("image", nil),
+	})
+	if err != nil || len(summaries) != 3 || strings.Join(done, " ") != "service container image" {
+		t.Errorf("wrong steps: %v, %+v, %v", done, summaries, err)
+	}
+
+	// the steps after a failing one depend on it, they are not run and the
+	// failure is returned with the summaries of the steps done
+	done = nil
+	summaries, err = purgeSteps(context.Background(), []purgeStep{
+		step("service", nil), step("container", errors.New("conflict")), step("image", nil),
+	})
+	if err == nil || !strings.Contains(err.Error(), "purging container: conflict") {
+		t.Errorf("the failure of a step should be returned: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Resource != "service" || strings.Join(done, " ") != "service container" {
+		t.Errorf("wrong steps after a failure: %v, %+v", done, summaries)
+	}
+}
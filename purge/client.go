@@ -0,0 +1,196 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// maxAPIVersion is the latest docker API that dkp is known to work with,
+	// newer daemons are talked to with it if they still support it
+	maxAPIVersion = "1.47"
+
+	// minAPIVersion is the first docker API with disk usage
+	minAPIVersion = "1.25"
+)
+
+var (
+	// apiVersion is the docker API to use, negotiated with the daemon if
+	// empty, see negotiateAPIVersion
+	apiVersion string
+
+	// requestTimeout bounds every request to docker, 0 is unlimited
+	requestTimeout time.Duration
+
+	// clientVersions are the API versions that the clients of newClient
+	// request, which go-dockerclient does not expose, see getJSON
+	clientVersions   = make(map[*docker.Client]string)
+	clientVersionsMu sync.Mutex
+)
+
+// dockerClient returns the shared docker client, connecting to dockerUri or,
+// if not given, to the docker of the environment on first use. The API
+// version is negotiated on first use unless --api-version or
+// DOCKER_API_VERSION is given.
+func dockerClient(ctx context.Context) (cli *docker.Client, err error) {
+	if client == nil {
+		version := apiVersion
+		if version == "" {
+			version = os.Getenv("DOCKER_API_VERSION")
+		}
+		if version == "" {
+			if version, err = negotiateAPIVersion(ctx, dockerUri); err != nil {
+				return
+			}
+		}
+		if client, err = newClient(dockerUri, version); err != nil {
+			return
+		}
+	}
+	// removals wait for containers to stop on the daemon side
+	timeout := requestTimeout
+	if timeout > 0 && stopRunning {
+		timeout += stopTimeout
+	}
+	client.SetTimeout(timeout)
+	return client, nil
+}
+
+// negotiateAPIVersion returns the API version of the daemon of uri, or
+// maxAPIVersion if the daemon is newer. It fails if that version is below
+// the MinAPIVersion of the daemon.
+func negotiateAPIVersion(ctx context.Context, uri string) (version string, err error) {
+	cli, err := newClient(uri, "")
+	if err != nil {
+		return
+	}
+	cli.SetTimeout(requestTimeout)
+	env, err := cli.VersionWithContext(ctx)
+	if err != nil {
+		return
+	}
+	server, err := docker.NewAPIVersion(env.Get("ApiVersion"))
+	if err != nil {
+		return "", fmt.Errorf("invalid API version of docker: %q", env.Get("ApiVersion"))
+	}
+	max, _ := docker.NewAPIVersion(maxAPIVersion)
+	min, _ := docker.NewAPIVersion(minAPIVersion)
+	if server.LessThan(min) {
+		return "", fmt.Errorf("docker API %s is too old, dkp needs %s or later", server, min)
+	}
+	version = server.String()
+	if server.GreaterThan(max) {
+		version = max.String()
+	}
+	// daemons before API 1.21 do not report their minimum
+	if env.Get("MinAPIVersion") == "" {
+		return
+	}
+	serverMin, err := docker.NewAPIVersion(env.Get("MinAPIVersion"))
+	if err != nil {
+		return "", fmt.Errorf("invalid minimum API version of docker: %q", env.Get("MinAPIVersion"))
+	}
+	if max.LessThan(serverMin) {
+		return "", fmt.Errorf("docker API %s or later is required by the daemon, dkp supports up to %s, see --api-version", serverMin, max)
+	}
+	return
+}
+
+// newClient creates a client of uri with clientTLS, or of the docker of the
+// environment if uri is empty. ssh hosts are reached without TLS, see
+// newSSHClient. version is the API version of requests, the latest of the
+// daemon if empty.
+func newClient(uri, version string) (cli *docker.Client, err error) {
+	if uri == "" && isSSH(os.Getenv("DOCKER_HOST")) {
+		uri = os.Getenv("DOCKER_HOST")
+	}
+	switch {
+	case isSSH(uri):
+		cli, err = newSSHClient(uri, version)
+	case clientTLS == nil && uri == "":
+		cli, err = docker.NewVersionedClientFromEnv(version)
+	case clientTLS == nil:
+		cli, err = docker.NewVersionedClient(uri, version)
+	default:
+		if uri == "" {
+			if uri = os.Getenv("DOCKER_HOST"); uri == "" {
+				uri = defaultTLSHost
+			}
+		}
+		cli, err = docker.NewVersionedTLSClientFromBytes(uri, clientTLS.cert, clientTLS.key, clientTLS.ca, version)
+	}
+	if err != nil {
+		return
+	}
+	// the version is requested as is, the daemon tells if it is not supported
+	cli.SkipServerVersionCheck = true
+	clientVersionsMu.Lock()
+	clientVersions[cli] = version
+	clientVersionsMu.Unlock()
+	return
+}
+
+// apiRequest sends a request of an API path to the daemon of cli, for the
+// calls that go-dockerclient does not decode or makes without a context.
+// The path is prefixed with the API version of cli like the requests of
+// go-dockerclient, unversioned if cli has none. Errors of the daemon are
+// returned as *docker.Error, the body of other responses must be closed.
+func apiRequest(ctx context.Context, cli *docker.Client, method, path string) (*http.Response, error) {
+	u, err := url.Parse(cli.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "unix" || u.Scheme == "npipe":
+		// the transport of the client dials the socket whatever the host
+		u = &url.URL{Scheme: "http", Host: "unix.sock"}
+	case u.Scheme == "tcp" && cli.TLSConfig != nil:
+		u.Scheme = "https"
+	case u.Scheme == "tcp":
+		u.Scheme = "http"
+	}
+	clientVersionsMu.Lock()
+	version := clientVersions[cli]
+	clientVersionsMu.Unlock()
+	if version != "" {
+		path = "/v" + version + path
+	}
+	if q := strings.Index(path, "?"); q >= 0 {
+		path, u.RawQuery = path[:q], path[q+1:]
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cli.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &docker.Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// getJSON decodes the response of an API path into v, see apiRequest
+func getJSON(ctx context.Context, cli *docker.Client, path string, v interface{}) error {
+	resp, err := apiRequest(ctx, cli, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,59 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	defer func(tls *dockerTLS) { clientTLS = tls }(clientTLS)
+	clientTLS = nil
+	for _, c := range []struct {
+		server, serverMin string
+		version           string
+		fails             bool
+	}{
+		{"1.40", "1.12", "1.40", false},
+		{"1.43", "1.12", "1.43", false},
+		{"1.45", "", "1.45", false},
+		{"1.51", "1.24", maxAPIVersion, false},
+		{"1.52", "1.48", "", true},
+		{"1.20", "1.12", "", true},
+		{"latest", "", "", true},
+		{"1.43", "oldest", "", true},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.serverMin == "" {
+				fmt.Fprintf(w, `{"ApiVersion":%q}`, c.server)
+			} else {
+				fmt.Fprintf(w, `{"ApiVersion":%q,"MinAPIVersion":%q}`, c.server, c.serverMin)
+			}
+		}))
+		version, err := negotiateAPIVersion(context.Background(), srv.URL)
+		srv.Close()
+		if (err != nil) != c.fails || version != c.version {
+			t.Errorf("wrong version negotiated with %s: %q, %v", c.server, version, err)
+		}
+	}
+}
+
+func TestNegotiateAPIVersionCanceled(t *testing.T) {
+	defer func(tls *dockerTLS) { clientTLS = tls }(clientTLS)
+	clientTLS = nil
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := negotiateAPIVersion(ctx, srv.URL); err == nil {
+		t.Error("a canceled negotiation should fail")
+	}
+}
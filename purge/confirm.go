@@ -0,0 +1,220 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// interactive asks which matched resources to remove before removing any
+	interactive bool
+
+	// confirmOver asks for confirmation when more resources than it match,
+	// 0 never asks
+	confirmOver int
+
+	// assumeYes removes matched resources without asking
+	assumeYes bool
+
+	// confirmIn and confirmOut are where answers are read and questions are
+	// written. Questions go to stderr to keep json and yaml output clean.
+	confirmIn  io.Reader = os.Stdin
+	confirmOut io.Writer = os.Stderr
+
+	// confirmTerminal tells if answers can be typed in, see needConfirm
+	confirmTerminal = isTerminal
+
+	// answers are the lines of confirmIn, shared by every prompt of a run
+	// so that the lines buffered after an answer are not lost, see readLine
+	answers *answerReader
+)
+
+// answerReader reads the lines of one input in a single goroutine. A line
+// read after its prompt is canceled is handed to the next prompt.
+type answerReader struct {
+	in    io.Reader
+	lines chan answerLine
+}
+
+// answerLine is a line read by answerReader with its error
+type answerLine struct {
+	line string
+	err  error
+}
+
+// newAnswerReader starts reading the lines of in. Reading stops at the
+// first error, which is then returned to every following read.
+func newAnswerReader(in io.Reader) *answerReader {
+	a := &answerReader{in: in, lines: make(chan answerLine)}
+	go func() {
+		r := bufio.NewReader(in)
+		for {
+			line, err := r.ReadString('\n')
+			a.lines <- answerLine{line, err}
+			if err != nil {
+				close(a.lines)
+				return
+			}
+		}
+	}()
+	return a
+}
+
+// Candidate is a matched resource shown for confirmation
+type Candidate struct {
+	ID      string
+	Names   []string
+	Size    int64
+	Created time.Time
+	Status  string
+}
+
+// needConfirm tells if the operator must confirm the removal of n resources.
+// confirmOver only applies when someone can answer on a terminal, unattended
+// runs such as cron jobs are not stopped by it.
+func needConfirm(n int) bool {
+	if dryRun || assumeYes || n == 0 {
+		return false
+	}
+	return interactive || confirmOver > 0 && n > confirmOver && confirmTerminal()
+}
+
+// isTerminal tells if confirmIn is a terminal
+func isTerminal() bool {
+	f, ok := confirmIn.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmCandidates asks which candidates to remove if needed, and returns
+// whether each of them is selected. Declined candidates are reported as
+// skipped and counted in summary. Waiting for the answer stops once ctx is
+// done.
+func confirmCandidates(ctx context.Context, summary *Summary, candidates []Candidate, filters []Filter) (selected []bool, err error) {
+	selected = make([]bool, len(candidates))
+	if !needConfirm(len(candidates)) {
+		for i := range selected {
+			selected[i] = true
+		}
+		return
+	}
+	printCandidates(confirmOut, summary.Resource, candidates)
+	for {
+		fmt.Fprintf(confirmOut, "Remove %d %ss? [a]ll, [n]one or numbers like 1,3-5: ", len(candidates), summary.Resource)
+		answer, e := readLine(ctx)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if e != nil && answer == "" {
+			return nil, errors.New("removal is not confirmed, use --yes to remove without asking")
+		}
+		if selected, err = parseSelection(answer, len(candidates)); err == nil {
+			break
+		}
+		fmt.Fprintln(confirmOut, err)
+	}
+	for i, c := range candidates {
+		if !selected[i] {
+			reporter.Record(newSkipRecord(summary.Resource, c.ID, c.Names, c.Size, filters, "not confirmed"))
+			summary.Skipped++
+		}
+	}
+	return
+}
+
+// readLine reads the next line of confirmIn, or returns the error of ctx
+// once it is done. The answers of confirmIn are started on the first read,
+// and again only if confirmIn is replaced.
+func readLine(ctx context.Context) (string, error) {
+	if answers == nil || answers.in != confirmIn {
+		answers = newAnswerReader(confirmIn)
+	}
+	select {
+	case r, ok := <-answers.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		return r.line, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// printCandidates prints a numbered table of candidates
+func printCandidates(out io.Writer, resource string, candidates []Candidate) {
+	if host != "" {
+		fmt.Fprintf(out, "%d %ss matched on %s:\n", len(candidates), resource, host)
+	} else {
+		fmt.Fprintf(out, "%d %ss matched:\n", len(candidates), resource)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tID\tNAMES\tSIZE\tAGE\tSTATUS")
+	for i, c := range candidates {
+		id := strings.TrimPrefix(c.ID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			i+1, id, strings.Join(c.Names, ","), HumanSize(c.Size), humanAge(c.Created), c.Status)
+	}
+	w.Flush()
+}
+
+// humanAge formats the time since t with its largest unit, "-" if unknown
+func humanAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dmin", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int(d/time.Second))
+}
+
+// parseSelection parses an answer: "a" or "all", "n" or "none", or numbers
+// and ranges of candidates separated by commas or spaces, e.g. "1,3-5"
+func parseSelection(answer string, n int) (selected []bool, err error) {
+	selected = make([]bool, n)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	switch answer {
+	case "a", "all", "y", "yes":
+		for i := range selected {
+			selected[i] = true
+		}
+		return
+	case "", "n", "none", "no":
+		return
+	}
+	for _, part := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		from, to := part, part
+		if dash := strings.Index(part, "-"); dash > 0 {
+			from, to = part[:dash], part[dash+1:]
+		}
+		first, e1 := strconv.Atoi(from)
+		last, e2 := strconv.Atoi(to)
+		if e1 != nil || e2 != nil || first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("invalid selection: %s, numbers are from 1 to %d", part, n)
+		}
+		for i := first; i <= last; i++ {
+			selected[i-1] = true
+		}
+	}
+	return
+}
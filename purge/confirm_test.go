@@ -0,0 +1,159 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSelection(t *testing.T) {
+	cases := map[string]string{
+		"a\n":     "11111",
+		"ALL":     "11111",
+		"":        "00000",
+		"none":    "00000",
+		"1,3-5":   "10111",
+		" 2 4 ":   "01010",
+		"5,1-1":   "10001",
+		"2-3,3-4": "01110",
+	}
+	for answer, expected := range cases {
+		selected, err := parseSelection(answer, 5)
+		if err != nil {
+			t.Errorf("parse selection error: %q, err: %s", answer, err)
+			continue
+		}
+		actual := ""
+		for _, s := range selected {
+			if s {
+				actual += "1"
+			} else {
+				actual += "0"
+			}
+		}
+		if actual != expected {
+			t.Errorf("wrong selection of %q: %s, expected: %s", answer, actual, expected)
+		}
+	}
+	for _, answer := range []string{"0", "6", "3-1", "x", "1-", "-2"} {
+		if _, err := parseSelection(answer, 5); err == nil {
+			t.Errorf("should fail to parse selection: %q", answer)
+		}
+	}
+}
+
+func TestNeedConfirm(t *testing.T) {
+	defer func(i, y, d bool, over int, term func() bool) {
+		interactive, assumeYes, dryRun, confirmOver, confirmTerminal = i, y, d, over, term
+	}(interactive, assumeYes, dryRun, confirmOver, confirmTerminal)
+
+	cases := []struct {
+		interactive, yes, dryRun, terminal bool
+		over, n                            int
+		expected                           bool
+	}{
+		{false, false, false, true, 50, 10, false},
+		{false, false, false, true, 50, 51, true},
+		{false, false, false, false, 50, 51, false},
+		{false, false, false, true, 0, 1000, false},
+		{true, false, false, true, 50, 1, true},
+		{true, false, false, false, 50, 1, true},
+		{true, false, false, true, 50, 0, false},
+		{true, true, false, true, 50, 10, false},
+		{false, false, true, true, 50, 100, false},
+	}
+	for _, c := range cases {
+		terminal := c.terminal
+		interactive, assumeYes, dryRun, confirmOver = c.interactive, c.yes, c.dryRun, c.over
+		confirmTerminal = func() bool { return terminal }
+		if needConfirm(c.n) != c.expected {
+			t.Errorf("wrong confirmation of %+v, expected: %v", c, c.expected)
+		}
+	}
+}
+
+func TestConfirmCandidates(t *testing.T) {
+	defer func(i bool, in io.Reader, out io.Writer, r Reporter) {
+		interactive, confirmIn, confirmOut, reporter = i, in, out, r
+	}(interactive, confirmIn, confirmOut, reporter)
+
+	interactive = true
+	records := &recordBuffer{}
+	reporter = records
+	out := &bytes.Buffer{}
+	confirmOut = out
+	var candidates []Candidate
+	for i := 1; i <= 3; i++ {
+		candidates = append(candidates, Candidate{ID: fmt.Sprintf("id%d", i), Created: time.Now().Add(-50 * time.Hour)})
+	}
+
+	// an invalid answer is asked again
+	confirmIn = strings.NewReader("9\n1,3\n")
+	summary := Summary{Resource: "image"}
+	selected, err := confirmCandidates(context.Background(), &summary, candidates, nil)
+	if err != nil {
+		t.Fatalf("confirm error: %s", err)
+	}
+	if !selected[0] || selected[1] || !selected[2] {
+		t.Errorf("wrong selection: %v", selected)
+	}
+	if summary.Skipped != 1 || len(records.records) != 1 || records.records[0].ID != "id2" {
+		t.Errorf("declined candidate is not reported: %+v, %+v", summary, records.records)
+	}
+	if !strings.Contains(out.String(), "invalid selection") || !strings.Contains(out.String(), "2d") {
+		t.Errorf("wrong output: %s", out)
+	}
+
+	confirmIn = strings.NewReader("")
+	confirmOut = ioutil.Discard
+	if _, err = confirmCandidates(context.Background(), &summary, candidates, nil); err == nil {
+		t.Error("no answer should fail")
+	}
+}
+
+func TestConfirmCandidatesSharedInput(t *testing.T) {
+	defer func(i bool, in io.Reader, out io.Writer, r Reporter) {
+		interactive, confirmIn, confirmOut, reporter = i, in, out, r
+	}(interactive, confirmIn, confirmOut, reporter)
+
+	interactive = true
+	reporter = &recordBuffer{}
+	confirmOut = ioutil.Discard
+	// piped answers of two prompts, e.g. of all -i or of several hosts
+	confirmIn = strings.NewReader("1\n2\n")
+	candidates := []Candidate{{ID: "id1"}, {ID: "id2"}}
+	for _, expected := range []int{0, 1} {
+		summary := Summary{Resource: "image"}
+		selected, err := confirmCandidates(context.Background(), &summary, candidates, nil)
+		if err != nil {
+			t.Fatalf("confirm error: %s", err)
+		}
+		if !selected[expected] || selected[1-expected] {
+			t.Errorf("wrong selection: %v, expected: %d", selected, expected+1)
+		}
+	}
+}
+
+func TestConfirmCandidatesCanceled(t *testing.T) {
+	defer func(i bool, in io.Reader, out io.Writer) {
+		interactive, confirmIn, confirmOut = i, in, out
+	}(interactive, confirmIn, confirmOut)
+
+	interactive = true
+	confirmOut = ioutil.Discard
+	// nobody answers
+	r, w := io.Pipe()
+	defer w.Close()
+	confirmIn = r
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary := Summary{Resource: "image"}
+	if _, err := confirmCandidates(ctx, &summary, []Candidate{{ID: "id1"}}, nil); err != context.Canceled {
+		t.Errorf("a cancelled confirmation should fail with the context: %v", err)
+	}
+}
@@ -1,213 +1,216 @@
 package purge
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/spf13/cobra"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-const (
-	ctnCreated = "Created"
-	ctnExited = "Exited"
-)
+// inspectParallel is how many containers are inspected at once
+const inspectParallel = 8
 
 var cmdCtn = &cobra.Command{
-	Use: "container",
+	Use:   "container",
 	Short: "Purge stopped containers",
-	Long: "Purge stopped containers",
-	RunE: RunCmdContainer,
+	Long:  "Purge stopped containers",
+	RunE:  RunCmdContainer,
 }
 
-type CtnFilter func(ctn docker.APIContainers) bool
+var (
+	// removeVolumes removes the anonymous volumes of removed containers
+	removeVolumes bool
 
+	// stopRunning stops running containers before removing them, waiting
+	// up to stopTimeout before they are killed
+	stopRunning bool
+	stopTimeout time.Duration
+)
 
-type CtnValidator struct {
-	Filters []CtnFilter
+// containerFields are the fields of containers that filters can compare
+var containerFields = map[string]Field{
+	"created":  {Kind: TimeKind},
+	"exited":   {Kind: TimeKind},
+	"started":  {Kind: TimeKind},
+	"finished": {Kind: TimeKind},
+	"name":     {Kind: StringKind},
 }
 
-func (c *CtnValidator) Satisfied(ctn docker.APIContainers) bool {
-	if len(c.Filters) == 0 {
-		return false
+// stateFields are the fields that need containers to be inspected
+var stateFields = []string{"exited", "started", "finished"}
+
+// containerResource adapts a container to Resource
+type containerResource struct {
+	docker.APIContainers
+
+	// state is the inspected state, nil unless a filter needs it
+	state *docker.State
+}
+
+func (c *containerResource) StringField(f string) (values []string) {
+	if f != "name" {
+		return
 	}
-	for _, Func := range c.Filters {
-		if !Func(ctn) {
-			return false
-		}
+	// names from the API have a leading slash, e.g. "/web"
+	for _, name := range c.APIContainers.Names {
+		values = append(values, strings.TrimPrefix(name, "/"))
 	}
-	return true
+	return
 }
 
-func NewCtnValidator(filters ...Filter) (c *CtnValidator, err error) {
-	c = new(CtnValidator)
-	var filter CtnFilter
-	for _, f := range filters {
-		switch f.Field {
-		case "created":
-			filter, err = GenFilterCreated(f)
-		case "exited":
-			filter, err = GenFilterExited(f)
-		default:
-			continue
+// IntField returns the times of the inspected state. started is unknown
+// for containers never started, finished for running ones, and exited is
+// the finished time of exited containers only.
+func (c *containerResource) IntField(f string) (int64, bool) {
+	if f == "created" {
+		return c.APIContainers.Created, true
+	}
+	if c.state == nil {
+		return 0, false
+	}
+	var t time.Time
+	switch f {
+	case "started":
+		t = c.state.StartedAt
+	case "finished":
+		if !c.state.Running {
+			t = c.state.FinishedAt
 		}
-		if err != nil {
-			return
+	case "exited":
+		if c.state.Status == "exited" {
+			t = c.state.FinishedAt
 		}
-		c.Filters = append(c.Filters, filter)
 	}
-	return
+	return t.Unix(), !t.IsZero()
 }
 
-// GenFilterCreated creates a filter that filter containers with created timestamp
-func GenFilterCreated(f Filter) (filter CtnFilter, err error) {
-	ago, err := parseDuration(f.Value)
-	if err != nil {
-		return
-	}
-	cmp, ok := int64Comparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
-	}
-	filter = func(ctn docker.APIContainers) bool {
-		return cmp(ago.Timestamp(), ctn.Created)
-	}
-	return
+func (c *containerResource) Labels() map[string]string    { return c.APIContainers.Labels }
+func (c *containerResource) ID() string                   { return c.APIContainers.ID }
+func (c *containerResource) Names() []string              { return c.APIContainers.Names }
+func (c *containerResource) Size() (unique, shared int64) { return c.SizeRw, 0 }
+func (c *containerResource) Created() time.Time           { return time.Unix(c.APIContainers.Created, 0) }
+func (c *containerResource) Status() string               { return c.APIContainers.Status }
+
+// containerPurger purges containers, stopping running ones first if
+// stopRunning is set
+type containerPurger struct{}
+
+func newContainerPurger() Purger {
+	return containerPurger{}
 }
 
+func (containerPurger) Type() string             { return "container" }
+func (containerPurger) Fields() map[string]Field { return containerFields }
 
-// GenFilterExited creates a filter that filter containers with exited time
-func GenFilterExited(f Filter) (filter CtnFilter, err error) {
-	ago, err := parseDuration(f.Value)
+func (containerPurger) List(ctx context.Context, cli *docker.Client) (resources []Resource, err error) {
+	containers, err := cli.ListContainers(docker.ListContainersOptions{All: true, Size: true, Context: ctx})
 	if err != nil {
 		return
 	}
-	cmp, ok := int64Comparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
-	}
-	filter = func(ctn docker.APIContainers) bool {
-		status, err := parseContainerStatus(ctn.Status)
-		if status.Status != ctnExited {
-			return false
-		}
-		if err != nil {
-			return false
-		}
-		exts, _ := status.ExitedTimestamp()
-		return cmp(ago.Timestamp(), exts)
+	for _, ctn := range containers {
+		resources = append(resources, &containerResource{APIContainers: ctn})
 	}
 	return
 }
 
-// CtnStatus stores container status
-type CtnStatus struct {
-	Status string
-	Code int
-	Num int
-	Unit string
+// Prepare inspects the containers if a filter needs their state
+func (containerPurger) Prepare(ctx context.Context, cli *docker.Client, resources []Resource, m *Matcher) error {
+	if !m.Uses(stateFields...) {
+		return nil
+	}
+	containers := make([]*containerResource, len(resources))
+	for i, r := range resources {
+		containers[i] = r.(*containerResource)
+	}
+	return inspectStates(ctx, cli, containers)
 }
 
-// ExitedTimestamp returns the exited timestamp of a container
-func (s *CtnStatus) ExitedTimestamp() (int64, error) {
-	if s.Status != ctnExited {
-		return 0, errors.New("status is not Exited")
-	}
-	now := time.Now()
-	ago := Ago{}
-	hours := 0
-	switch s.Unit {
-	case "years":
-		ago.Years = s.Num
-	case "months":
-		ago.Months = s.Num
-	case "weeks":
-		ago.Days = s.Num * 7
-	case "days":
-		ago.Days = s.Num
-	case "hours":
-		hours = s.Num
-	}
-	then := now.AddDate(-ago.Years, -ago.Months, -ago.Days)
-	then = then.Add(-time.Duration(hours)*time.Hour)
-	return then.Unix(), nil
+func (containerPurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	ctn := r.(*containerResource).APIContainers
+	if err := stopContainer(ctx, cli, ctn); err != nil {
+		return err
+	}
+	return cli.RemoveContainer(docker.RemoveContainerOptions{ID: ctn.ID, RemoveVolumes: removeVolumes, Force: force, Context: ctx})
 }
 
-
-
 func RunCmdContainer(cmd *cobra.Command, args []string) error {
 	var filters []Filter
 	for _, f := range filter {
-		fmt.Println("Filter: ", f)
+		reporter.Filter(f)
 		parsed, err := parseFilter(f)
 		if err != nil {
 			return err
 		}
 		filters = append(filters, parsed)
 	}
-	return RemoveContainers(filters...)
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return single(RemoveContainers(ctx, filters...))
+	})
 }
 
-
-func RemoveContainers(filters ...Filter) (err error) {
-	var cli *docker.Client
-	if dockerUri == "" {
-		cli, err = docker.NewClientFromEnv()
-	} else {
-		cli, err = docker.NewClient(dockerUri)
+// stopContainer stops a running container with stopTimeout if stopRunning
+// is set. A container that stopped meanwhile is not an error.
+func stopContainer(ctx context.Context, cli *docker.Client, ctn docker.APIContainers) error {
+	if !stopRunning || !isRunning(ctn) {
+		return nil
 	}
-	if err != nil {
-		return
+	err := cli.StopContainerWithContext(ctn.ID, uint(stopTimeout.Seconds()), ctx)
+	if _, ok := err.(*docker.ContainerNotRunning); ok {
+		return nil
 	}
-	validator, err := NewCtnValidator(filters...)
 	if err != nil {
-		return
+		return fmt.Errorf("stop: %s", err)
 	}
+	return nil
+}
 
-	containers, err := cli.ListContainers(docker.ListContainersOptions{All:true})
-	if err != nil {
-		return
-	}
-	for _, ctn := range containers {
-		if validator.Satisfied(ctn) {
-			if dryRun {
-				fmt.Println("[DryRun]Removing container ", ctn.ID)
-				continue
-			}
-			fmt.Println("Removing container ", ctn.ID)
-			e := cli.RemoveContainer(docker.RemoveContainerOptions{ID:ctn.ID})
-			if e != nil {
-				fmt.Printf("Can not remove container: %s, reason: %s", ctn.ID, e)
-			}
-		}
+// isRunning tells if a container must be stopped before it is removed
+func isRunning(ctn docker.APIContainers) bool {
+	switch ctn.State {
+	case "running", "paused", "restarting":
+		return true
 	}
-	return
+	return false
+}
+
+// RemoveContainers purges containers with filters, see containerPurger
+func RemoveContainers(ctx context.Context, filters ...Filter) (Summary, error) {
+	return Purge(ctx, newContainerPurger(), filters...)
 }
 
-// parseContainerStatus parses container status with statusPtn
-func parseContainerStatus(s string) (a *CtnStatus, err error) {
-	a = new(CtnStatus)
-	m := statusPtn.FindStringSubmatch(s)
-	for i, name := range statusPtn.SubexpNames() {
-		if i != 0 && name != "" && m[i] != "" {
-			switch name {
-			case "status":
-				a.Status = m[i]
-			case "code":
-				a.Code, err = strconv.Atoi(m[i])
-			case "num":
-				a.Num, err = strconv.Atoi(m[i])
-			case "unit":
-				a.Unit = m[i]
+// inspectStates sets the state of containers, inspecting up to
+// inspectParallel of them at once. A container removed meanwhile keeps no
+// state. Listed containers carry nothing that changes when a container
+// restarts, so states are not cached between purges: a stopped container
+// restarted and exited with the same code would keep its old FinishedAt.
+func inspectStates(ctx context.Context, cli *docker.Client, containers []*containerResource) error {
+	errs := make([]error, len(containers))
+	sem := make(chan struct{}, inspectParallel)
+	var wg sync.WaitGroup
+	for i, c := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c *containerResource) {
+			defer func() { <-sem; wg.Done() }()
+			ctn, err := cli.InspectContainerWithOptions(docker.InspectContainerOptions{ID: c.APIContainers.ID, Context: ctx})
+			if _, ok := err.(*docker.NoSuchContainer); ok {
+				return
 			}
 			if err != nil {
+				errs[i] = fmt.Errorf("inspecting container %s: %s", c.APIContainers.ID, err)
 				return
 			}
+			c.state = &ctn.State
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
-
-	return a, nil
+	return nil
 }
@@ -1,50 +1,192 @@
 package purge
 
 import (
-	"github.com/fsouza/go-dockerclient"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/fsouza/go-dockerclient"
 )
 
-func TestGenFilterCreated(t *testing.T) {
+func TestContainerCreatedFilter(t *testing.T) {
 	f := Filter{"created>10d", "created", GT, "10d"}
-	fn, err := GenFilterCreated(f)
+	m, err := NewMatcher(containerFields, f)
 	if err != nil {
-		t.Error("error when creating filter function", err)
+		t.Error("error when creating matcher", err)
 	}
 	yesterday := time.Now().AddDate(0, 0, -1)
 
-	ctn := docker.APIContainers{Created:yesterday.Unix()}
-	ok := fn(ctn)
-	if ok {
+	ctn := docker.APIContainers{Created: yesterday.Unix()}
+	if m.Satisfied(&containerResource{APIContainers: ctn}) {
 		t.Errorf("filter the wrong result. created 1d ago")
 	}
-	ctn.Created = yesterday.AddDate(0,-1, 0).Unix()
-	ok = fn(ctn)
-	if !ok {
+	ctn.Created = yesterday.AddDate(0, -1, 0).Unix()
+	if !m.Satisfied(&containerResource{APIContainers: ctn}) {
 		t.Errorf("wrong filter result: created: 1m1d ago")
 	}
 }
 
-
-func TestGenFilterExited(t *testing.T) {
+func TestContainerExitedFilter(t *testing.T) {
 	f := Filter{"exited>1m2d", "exited", GT, "1m2d"}
-	fn, err := GenFilterExited(f)
+	m, err := NewMatcher(containerFields, f)
 	if err != nil {
-		t.Error("error when creating filter function", err)
+		t.Error("error when creating matcher", err)
 	}
-	//now := time.Now()
-	ctn := docker.APIContainers{Status: "Exited (143) 20 weeks ago"}
-	if ok := fn(ctn); !ok {
+	exited := &docker.State{Status: "exited", FinishedAt: time.Now().AddDate(0, 0, -140)}
+	if !m.Satisfied(&containerResource{state: exited}) {
 		t.Error("wrong filter result. exited 20 weeks")
 	}
-	ctn.Status = "Exited (143) 2 days ago"
-	if ok := fn(ctn); ok {
+	exited.FinishedAt = time.Now().AddDate(0, 0, -2)
+	if m.Satisfied(&containerResource{state: exited}) {
 		t.Error("wrong filter result. exited 2 days")
 	}
-	ctn.Status = "Up 2 seconds"
-	if ok := fn(ctn); ok {
-		t.Error("wrong filter result. Up 2 seconds, Not exited.")
+	// the exact time is compared, not the status text
+	exited.FinishedAt = time.Now().AddDate(0, -1, -2).Add(-time.Minute)
+	if !m.Satisfied(&containerResource{state: exited}) {
+		t.Error("wrong filter result. exited 1 month 2 days and 1 minute")
+	}
+	running := &docker.State{Status: "running", Running: true, FinishedAt: time.Now().AddDate(-1, 0, 0)}
+	if m.Satisfied(&containerResource{state: running}) {
+		t.Error("wrong filter result. running, Not exited.")
+	}
+	// a container that is not exited does not pass the negation either
+	m, _ = NewMatcher(containerFields, Filter{"exited<1m2d", "exited", LT, "1m2d"})
+	if m.Satisfied(&containerResource{state: running}) {
+		t.Error("wrong filter result. running, Not exited.")
+	}
+	if m.Satisfied(&containerResource{}) {
+		t.Error("wrong filter result. not inspected.")
+	}
+}
+
+func TestContainerStartedFinishedFilter(t *testing.T) {
+	m, err := NewMatcher(containerFields, Filter{"started>6h", "started", GT, "6h"}, Filter{"finished<1h", "finished", LT, "1h"})
+	if err != nil {
+		t.Fatal("error when creating matcher", err)
+	}
+	if !m.Uses(stateFields...) {
+		t.Error("the matcher should need states")
+	}
+	state := &docker.State{Status: "exited", StartedAt: time.Now().Add(-7 * time.Hour), FinishedAt: time.Now().Add(-30 * time.Minute)}
+	if !m.Satisfied(&containerResource{state: state}) {
+		t.Error("wrong filter result. started 7h ago, finished 30min ago")
+	}
+	state.Running = true
+	if m.Satisfied(&containerResource{state: state}) {
+		t.Error("wrong filter result. running containers have not finished")
+	}
+	m, _ = NewMatcher(containerFields, Filter{"started<1d", "started", LT, "1d"})
+	if m.Satisfied(&containerResource{state: &docker.State{Status: "created"}}) {
+		t.Error("wrong filter result. never started")
+	}
+	if m, _ = NewMatcher(containerFields, Filter{"created>1d", "created", GT, "1d"}); m.Uses(stateFields...) {
+		t.Error("the matcher should not need states")
+	}
+}
+
+func TestInspectStates(t *testing.T) {
+	var mu sync.Mutex
+	inspected := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+		mu.Lock()
+		inspected[id]++
+		mu.Unlock()
+		if id == "gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"Id":%q,"State":{"Status":"exited","FinishedAt":"2024-01-02T03:04:05Z"}}`, id)
+	}))
+	defer srv.Close()
+	cli, err := docker.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() []*containerResource {
+		return []*containerResource{
+			{APIContainers: docker.APIContainers{ID: "a", State: "exited", Status: "Exited (0) 2 days ago"}},
+			{APIContainers: docker.APIContainers{ID: "b", State: "running", Status: "Up 2 hours"}},
+			{APIContainers: docker.APIContainers{ID: "gone", State: "exited", Status: "Exited (1) 3 days ago"}},
+		}
+	}
+	containers := list()
+	if err = inspectStates(context.Background(), cli, containers); err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if containers[0].state == nil || !containers[0].state.FinishedAt.Equal(finished) || containers[1].state == nil {
+		t.Errorf("wrong states: %+v, %+v", containers[0].state, containers[1].state)
+	}
+	if containers[2].state != nil {
+		t.Error("a removed container should have no state")
+	}
+
+	// a container restarted and exited with the same code is inspected again
+	containers = list()
+	if err = inspectStates(context.Background(), cli, containers); err != nil {
+		t.Fatal(err)
+	}
+	if inspected["a"] != 2 || inspected["b"] != 2 || containers[0].state == nil {
+		t.Errorf("wrong inspections: %v", inspected)
+	}
+}
+
+func TestContainerNameFilter(t *testing.T) {
+	f := Filter{"name*=ci-*", "name", GLOB, "ci-*"}
+	m, err := NewMatcher(containerFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if !m.Satisfied(&containerResource{APIContainers: docker.APIContainers{Names: []string{"/ci-build-42"}}}) {
+		t.Errorf("should pass filter. filter: %s", f.Source)
+	}
+	if m.Satisfied(&containerResource{APIContainers: docker.APIContainers{Names: []string{"/web"}}}) {
+		t.Errorf("should not pass filter. filter: %s", f.Source)
+	}
+}
+
+func TestContainerLabelFilter(t *testing.T) {
+	f, err := parseFilter("!label.keep")
+	if err != nil {
+		t.Fatalf("parse filter error: %s", err)
+	}
+	m, err := NewMatcher(containerFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if m.Satisfied(&containerResource{APIContainers: docker.APIContainers{Labels: map[string]string{"keep": ""}}}) {
+		t.Errorf("should not pass filter. filter: %s", f.Source)
+	}
+	if !m.Satisfied(&containerResource{}) {
+		t.Errorf("should pass filter. filter: %s", f.Source)
 	}
 }
 
+func TestContainerUnknownField(t *testing.T) {
+	if _, err := NewMatcher(containerFields, Filter{"tag=old", "tag", EQ, "old"}); err == nil {
+		t.Error("unknown field should fail")
+	}
+}
+
+func TestIsRunning(t *testing.T) {
+	cases := map[string]bool{
+		"running":    true,
+		"paused":     true,
+		"restarting": true,
+		"exited":     false,
+		"created":    false,
+		"dead":       false,
+	}
+	for state, expected := range cases {
+		if isRunning(docker.APIContainers{State: state}) != expected {
+			t.Errorf("wrong running state of %s, expected: %v", state, expected)
+		}
+	}
+}
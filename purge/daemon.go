@@ -0,0 +1,167 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+var cmdDaemon = &cobra.Command{
+	Use:   "daemon",
+	Short: "Purge resources periodically",
+	Long: "Purge resources on a schedule, with the rules of a policy file or the filters of each resource type " +
+		"like the all command. Runs never overlap, a run that is late is skipped. " +
+		"On SIGTERM or interrupt, the current run is finished before exiting, a second signal cancels it",
+	RunE: RunCmdDaemon,
+}
+
+var (
+	// interval is the time between runs of the daemon
+	interval time.Duration
+
+	// cronSpec is a cron expression of the runs of the daemon
+	cronSpec string
+)
+
+// parseSchedule creates the schedule of the daemon, exactly one of interval
+// and spec must be given
+func parseSchedule(interval time.Duration, spec string) (schedule cron.Schedule, err error) {
+	switch {
+	case interval != 0 && spec != "":
+		return nil, errors.New("--interval and --cron can not be used together")
+	case interval < 0:
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	case interval > 0:
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval is less than 1s: %s", interval)
+		}
+		return cron.Every(interval), nil
+	case spec != "":
+		schedule, err = cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %s, reason: %s", spec, err)
+		}
+		return
+	}
+	return nil, errors.New("either --interval or --cron is required")
+}
+
+// runSchedule calls run at every time of schedule until stop is closed.
+// run is called synchronously, so runs never overlap, and times that passed
+// during a run are skipped. A run in progress is not interrupted by stop.
+func runSchedule(schedule cron.Schedule, stop <-chan struct{}, run func()) {
+	for {
+		timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// stop may be closed while the timer fired as well
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		run()
+	}
+}
+
+// daemonRun returns the purge of each run, with the rules of policyFile if
+// given, otherwise with the filters of each resource type.
+// Everything is checked before the first run.
+func daemonRun() (purge func(ctx context.Context) ([]Summary, error), err error) {
+	if policyFile != "" {
+		if len(allSvcFilter)+len(allCtnFilter)+len(allImgFilter)+len(allVolFilter)+len(allNetFilter) > 0 {
+			return nil, errors.New("--config can not be used with --service, --container, --image, --volume or --network")
+		}
+		policy, err := LoadPolicy(policyFile)
+		if err != nil {
+			return nil, err
+		}
+		return policy.Apply, nil
+	}
+	steps, err := allSteps()
+	if err != nil {
+		return
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("nothing to purge, use --config or filters of resource types")
+	}
+	return func(ctx context.Context) ([]Summary, error) { return purgeSteps(ctx, steps) }, nil
+}
+
+func RunCmdDaemon(cmd *cobra.Command, args []string) error {
+	if len(filter) > 0 || where != "" {
+		return errors.New("-f and --where are ambiguous for daemon, use --config or filters of resource types")
+	}
+	if interactive {
+		return errors.New("--interactive can not be used with daemon")
+	}
+	// nobody answers a daemon
+	assumeYes = true
+	schedule, err := parseSchedule(interval, cronSpec)
+	if err != nil {
+		return err
+	}
+	purge, err := daemonRun()
+	if err != nil {
+		return err
+	}
+	// connect once, the client is kept for all runs. Several hosts are
+	// connected on their first run, an unreachable one is retried on the
+	// next run.
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+	if len(hosts) <= 1 {
+		if _, err = dockerClient(ctx); err != nil {
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, exiting after the current run, send it again to cancel the run", sig)
+		close(stop)
+		select {
+		case sig = <-signals:
+			log.Printf("received %s, canceling the current run", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Printf("daemon started, next run at %s", schedule.Next(time.Now()).Format(time.RFC3339))
+	runs := 0
+	runSchedule(schedule, stop, func() {
+		runs++
+		start := time.Now()
+		log.Printf("run #%d started", runs)
+		summaries, err := forEachHost(ctx, purge)
+		reporter.Summary(summaries...)
+		total := totalSummary(summaries...)
+		if err != nil {
+			// a failed run is retried on the next one
+			log.Printf("run #%d failed after %s: %s", runs, time.Since(start), err)
+		} else {
+			log.Printf("run #%d finished in %s: removed %d, skipped %d, failed %d, reclaimed %s",
+				runs, time.Since(start), total.Removed, total.Skipped, total.Failed, HumanSize(total.Reclaimed))
+		}
+		log.Printf("next run at %s", schedule.Next(time.Now()).Format(time.RFC3339))
+	})
+	log.Printf("daemon stopped after %d runs", runs)
+	return nil
+}
@@ -0,0 +1,79 @@
+package purge
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.Local)
+	cases := []struct {
+		interval time.Duration
+		spec     string
+		next     time.Time
+	}{
+		{time.Hour, "", now.Add(time.Hour)},
+		{0, "0 3 * * *", time.Date(2020, 1, 2, 3, 0, 0, 0, time.Local)},
+		{0, "@hourly", time.Date(2020, 1, 1, 11, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		schedule, err := parseSchedule(c.interval, c.spec)
+		if err != nil {
+			t.Errorf("parse schedule error: %s %q, err: %s", c.interval, c.spec, err)
+			continue
+		}
+		if next := schedule.Next(now); !next.Equal(c.next) {
+			t.Errorf("wrong next run of %s %q: %s, expected: %s", c.interval, c.spec, next, c.next)
+		}
+	}
+	for _, c := range []struct {
+		interval time.Duration
+		spec     string
+	}{
+		{0, ""},
+		{time.Hour, "@hourly"},
+		{-time.Hour, ""},
+		{time.Millisecond, ""},
+		{0, "* * *"},
+	} {
+		if _, err := parseSchedule(c.interval, c.spec); err == nil {
+			t.Errorf("should fail to parse schedule: %s %q", c.interval, c.spec)
+		}
+	}
+}
+
+// testSchedule fires every duration
+type testSchedule time.Duration
+
+func (s testSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+func TestRunSchedule(t *testing.T) {
+	stop := make(chan struct{})
+	var runs, running int32
+	done := make(chan struct{})
+	go func() {
+		runSchedule(testSchedule(time.Millisecond), stop, func() {
+			if atomic.AddInt32(&running, 1) > 1 {
+				t.Error("runs overlap")
+			}
+			// longer than the schedule
+			time.Sleep(5 * time.Millisecond)
+			if atomic.AddInt32(&runs, 1) == 3 {
+				close(stop)
+			}
+			atomic.AddInt32(&running, -1)
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule is not stopped")
+	}
+	if runs != 3 {
+		t.Errorf("wrong number of runs: %d, expected: 3", runs)
+	}
+}
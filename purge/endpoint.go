@@ -0,0 +1,204 @@
+package purge
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// defaultTLSHost is the docker host of TLS clients when none is given, like
+// the docker CLI
+const defaultTLSHost = "tcp://localhost:2376"
+
+var (
+	// tlsVerify verifies the certificate of the daemon with tlsCACert
+	tlsVerify bool
+
+	// tlsCACert, tlsCert and tlsKey are paths of PEM files. They default to
+	// ca.pem, cert.pem and key.pem of DOCKER_CERT_PATH or ~/.docker.
+	tlsCACert string
+	tlsCert   string
+	tlsKey    string
+
+	// contextName is a context of the docker CLI, see resolveContext
+	contextName string
+
+	// clientTLS is the TLS material of the clients, nil without TLS
+	clientTLS *dockerTLS
+)
+
+// dockerTLS holds PEM blocks for docker.NewTLSClientFromBytes. Without ca,
+// the certificate of the daemon is not verified.
+type dockerTLS struct {
+	ca, cert, key []byte
+}
+
+// contextMeta is the meta.json of a context in the contexts store of the
+// docker CLI
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// setupEndpoint selects the docker hosts and the TLS material of clients
+// with --docker, --inventory, the TLS flags and --context. Without any of
+// them, the current context of the docker CLI is used, if any.
+func setupEndpoint() (err error) {
+	tlsFlags := tlsVerify || tlsCACert != "" || tlsCert != "" || tlsKey != ""
+	uris := dockerUris
+	name := contextName
+	if name != "" && (len(uris) > 0 || inventory != "" || tlsFlags) {
+		return errors.New("--context can not be used with --docker, --inventory or TLS flags")
+	}
+	if name == "" && len(uris) == 0 && inventory == "" && !tlsFlags {
+		if name, err = currentContext(); err != nil {
+			return
+		}
+	}
+	if name != "" && name != "default" {
+		var uri string
+		if uri, clientTLS, err = resolveContext(name); err != nil {
+			return
+		}
+		uris = []string{uri}
+	} else if clientTLS, err = loadTLS(tlsVerify, tlsCACert, tlsCert, tlsKey); err != nil {
+		return
+	}
+	if hosts, err = dockerHosts(uris, inventory); err != nil {
+		return
+	}
+	if len(hosts) == 1 {
+		dockerUri = hosts[0]
+	}
+	return
+}
+
+// loadTLS reads the PEM files of the TLS flags. It returns nil if none of
+// them is given. The CA is only read to verify the daemon.
+func loadTLS(verify bool, ca, cert, key string) (t *dockerTLS, err error) {
+	if !verify && ca == "" && cert == "" && key == "" {
+		return nil, nil
+	}
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		dir = dockerConfigDir()
+	}
+	t = new(dockerTLS)
+	if t.cert, err = readPEM(cert, filepath.Join(dir, "cert.pem")); err != nil {
+		return nil, err
+	}
+	if t.key, err = readPEM(key, filepath.Join(dir, "key.pem")); err != nil {
+		return nil, err
+	}
+	if (t.cert == nil) != (t.key == nil) {
+		return nil, errors.New("--tlscert and --tlskey must be given together")
+	}
+	if !verify {
+		return
+	}
+	if t.ca, err = readPEM(ca, filepath.Join(dir, "ca.pem")); err != nil {
+		return nil, err
+	}
+	if t.ca == nil {
+		return nil, errors.New("--tlsverify requires a CA certificate, see --tlscacert")
+	}
+	return
+}
+
+// readPEM reads path, or def if path is empty. A missing def is not an
+// error, it returns nil.
+func readPEM(path, def string) ([]byte, error) {
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+	data, err := ioutil.ReadFile(def)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// dockerConfigDir is the configuration directory of the docker CLI
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the context that the docker CLI uses by default:
+// DOCKER_CONTEXT, nothing if DOCKER_HOST is set, or the current context of
+// its config.json. "" means the docker of the environment.
+func currentContext() (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	if os.Getenv("DOCKER_HOST") != "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("invalid docker config: %s", err)
+	}
+	return config.CurrentContext, nil
+}
+
+// resolveContext reads the docker endpoint of a context and its TLS
+// material from the contexts store of the docker CLI. Contexts are stored
+// under the SHA-256 of their name:
+//
+//	contexts/meta/<id>/meta.json
+//	contexts/tls/<id>/docker/{ca,cert,key}.pem
+func resolveContext(name string) (uri string, t *dockerTLS, err error) {
+	dir := filepath.Join(dockerConfigDir(), "contexts")
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("docker context not found: %s", name)
+	}
+	if err != nil {
+		return
+	}
+	var meta contextMeta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return "", nil, fmt.Errorf("invalid docker context %s: %s", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return "", nil, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+	tlsDir := filepath.Join(dir, "tls", id, "docker")
+	t = new(dockerTLS)
+	for _, f := range []struct {
+		file string
+		pem  *[]byte
+	}{{"ca.pem", &t.ca}, {"cert.pem", &t.cert}, {"key.pem", &t.key}} {
+		if *f.pem, err = readPEM("", filepath.Join(tlsDir, f.file)); err != nil {
+			return
+		}
+	}
+	if t.ca == nil && t.cert == nil && t.key == nil && !endpoint.SkipTLSVerify {
+		return endpoint.Host, nil, nil
+	}
+	if endpoint.SkipTLSVerify {
+		t.ca = nil
+	}
+	return endpoint.Host, t, nil
+}
@@ -0,0 +1,132 @@
+package purge
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeContext writes a context to the contexts store of dir with the TLS
+// files given by name
+func writeContext(t *testing.T, dir, name, meta string, files ...string) {
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	metaDir := filepath.Join(dir, "contexts", "meta", id)
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	for _, d := range []string{metaDir, tlsDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(tlsDir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	writeContext(t, dir, "prod", `{"Name":"prod","Endpoints":{"docker":{"Host":"tcp://prod:2376","SkipTLSVerify":false}}}`,
+		"ca.pem", "cert.pem", "key.pem")
+	uri, tls, err := resolveContext("prod")
+	if err != nil {
+		t.Fatalf("resolve context error: %s", err)
+	}
+	if uri != "tcp://prod:2376" || tls == nil || string(tls.ca) != "ca.pem" || string(tls.cert) != "cert.pem" || string(tls.key) != "key.pem" {
+		t.Errorf("wrong endpoint of context: %s %+v", uri, tls)
+	}
+
+	writeContext(t, dir, "insecure", `{"Name":"insecure","Endpoints":{"docker":{"Host":"tcp://lab:2376","SkipTLSVerify":true}}}`,
+		"ca.pem")
+	if uri, tls, err = resolveContext("insecure"); err != nil || tls == nil || tls.ca != nil {
+		t.Errorf("the daemon should not be verified: %s %+v %v", uri, tls, err)
+	}
+
+	writeContext(t, dir, "plain", `{"Name":"plain","Endpoints":{"docker":{"Host":"ssh://ci@agent"}}}`)
+	if uri, tls, err = resolveContext("plain"); err != nil || uri != "ssh://ci@agent" || tls != nil {
+		t.Errorf("wrong endpoint of context without TLS: %s %+v %v", uri, tls, err)
+	}
+
+	writeContext(t, dir, "k8s", `{"Name":"k8s","Endpoints":{"kubernetes":{"Host":"https://k8s"}}}`)
+	for _, name := range []string{"k8s", "missing"} {
+		if _, _, err = resolveContext(name); err == nil {
+			t.Errorf("context %s should fail", name)
+		}
+	}
+}
+
+func TestCurrentContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, env := range []string{"DOCKER_CONFIG", "DOCKER_CONTEXT", "DOCKER_HOST"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	if name, err := currentContext(); err != nil || name != "" {
+		t.Errorf("no config should mean no context: %q %v", name, err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"prod"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := currentContext(); name != "prod" {
+		t.Errorf("wrong current context: %q", name)
+	}
+	os.Setenv("DOCKER_HOST", "tcp://other:2375")
+	if name, _ := currentContext(); name != "" {
+		t.Errorf("DOCKER_HOST should win over the current context: %q", name)
+	}
+	os.Setenv("DOCKER_CONTEXT", "lab")
+	if name, _ := currentContext(); name != "lab" {
+		t.Errorf("DOCKER_CONTEXT should win: %q", name)
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("DOCKER_CERT_PATH", os.Getenv("DOCKER_CERT_PATH"))
+	os.Setenv("DOCKER_CERT_PATH", dir)
+
+	if tls, err := loadTLS(false, "", "", ""); tls != nil || err != nil {
+		t.Errorf("no TLS flag should mean no TLS: %+v %v", tls, err)
+	}
+	if _, err = loadTLS(true, "", "", ""); err == nil {
+		t.Error("--tlsverify without CA should fail")
+	}
+	for _, f := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tls, err := loadTLS(true, "", "", "")
+	if err != nil || string(tls.ca) != "ca.pem" || string(tls.cert) != "cert.pem" || string(tls.key) != "key.pem" {
+		t.Errorf("wrong TLS from DOCKER_CERT_PATH: %+v %v", tls, err)
+	}
+	if tls, err = loadTLS(false, "", filepath.Join(dir, "cert.pem"), ""); err != nil || tls.ca != nil {
+		t.Errorf("the daemon should not be verified without --tlsverify: %+v %v", tls, err)
+	}
+	if _, err = loadTLS(false, "", filepath.Join(dir, "missing.pem"), ""); err == nil {
+		t.Error("a missing --tlscert should fail")
+	}
+}
@@ -0,0 +1,304 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Predicate tells if a resource, e.g. a docker.APIImages, satisfies a condition
+type Predicate func(resource interface{}) bool
+
+// LeafCompiler turns a single filter of an expression into a Predicate of
+// one type of resource
+type LeafCompiler func(f Filter) (Predicate, error)
+
+// Expr is the AST of a --where expression
+type Expr interface {
+	// Compile builds a Predicate of the expression, compiling the filters
+	// at its leaves with leaf
+	Compile(leaf LeafCompiler) (Predicate, error)
+
+	String() string
+}
+
+type andExpr struct {
+	left, right Expr
+}
+
+func (e *andExpr) Compile(leaf LeafCompiler) (Predicate, error) {
+	left, err := e.left.Compile(leaf)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.Compile(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return func(r interface{}) bool { return left(r) && right(r) }, nil
+}
+
+func (e *andExpr) String() string {
+	return fmt.Sprintf("(%s and %s)", e.left, e.right)
+}
+
+type orExpr struct {
+	left, right Expr
+}
+
+func (e *orExpr) Compile(leaf LeafCompiler) (Predicate, error) {
+	left, err := e.left.Compile(leaf)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.Compile(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return func(r interface{}) bool { return left(r) || right(r) }, nil
+}
+
+func (e *orExpr) String() string {
+	return fmt.Sprintf("(%s or %s)", e.left, e.right)
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+func (e *notExpr) Compile(leaf LeafCompiler) (Predicate, error) {
+	p, err := e.expr.Compile(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return func(r interface{}) bool { return !p(r) }, nil
+}
+
+func (e *notExpr) String() string {
+	return fmt.Sprintf("not %s", e.expr)
+}
+
+// filterExpr is a leaf of the AST. A field without operator, e.g.
+// "label.keep", has empty Comparator and Value.
+type filterExpr struct {
+	filter Filter
+}
+
+func (e *filterExpr) Compile(leaf LeafCompiler) (Predicate, error) {
+	return leaf(e.filter)
+}
+
+func (e *filterExpr) String() string {
+	return e.filter.Source
+}
+
+const (
+	tokLParen = iota
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokFilter
+)
+
+type token struct {
+	kind   int
+	filter Filter
+	pos    int
+}
+
+// operators of filters, longer ones first
+var exprOps = []Op{NE, LTE, GTE, MATCH, GLOB, PREFIX, EQ, LT, GT}
+
+// lexExpr splits an expression into tokens
+func lexExpr(s string) (tokens []token, err error) {
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i})
+			i++
+		default:
+			start := i
+			// "!label.keep" checks that a label is absent
+			absent := c == '!' && i+1 < len(s) && isFieldChar(rune(s[i+1]))
+			if absent {
+				i++
+			}
+			for i < len(s) && isFieldChar(rune(s[i])) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("unexpected %q at %d", s[i], i)
+			}
+			field := s[start:i]
+			switch strings.ToLower(field) {
+			case "and":
+				tokens = append(tokens, token{kind: tokAnd, pos: start})
+				continue
+			case "or":
+				tokens = append(tokens, token{kind: tokOr, pos: start})
+				continue
+			case "not":
+				tokens = append(tokens, token{kind: tokNot, pos: start})
+				continue
+			}
+			if absent {
+				tokens = append(tokens, token{kind: tokFilter, filter: Filter{s[start:i], field[1:], ABSENT, ""}, pos: start})
+				continue
+			}
+			f := Filter{Field: field}
+			for _, op := range exprOps {
+				if strings.HasPrefix(s[i:], string(op)) {
+					f.Comparator = op
+					i += len(op)
+					break
+				}
+			}
+			if f.Comparator != "" {
+				f.Value, i, err = lexValue(s, i)
+				if err != nil {
+					return nil, err
+				}
+			}
+			f.Source = s[start:i]
+			tokens = append(tokens, token{kind: tokFilter, filter: f, pos: start})
+		}
+	}
+	return
+}
+
+// lexValue reads the value of a filter at i, either "quoted" or bare till
+// a space or parenthesis
+func lexValue(s string, i int) (value string, end int, err error) {
+	if i < len(s) && s[i] == '"' {
+		closing := strings.IndexByte(s[i+1:], '"')
+		if closing < 0 {
+			return "", i, fmt.Errorf("unterminated quote at %d", i)
+		}
+		return s[i+1 : i+1+closing], i + closing + 2, nil
+	}
+	start := i
+	for i < len(s) && !unicode.IsSpace(rune(s[i])) && s[i] != '(' && s[i] != ')' {
+		i++
+	}
+	if i == start {
+		return "", i, fmt.Errorf("missing value at %d", i)
+	}
+	return s[start:i], i, nil
+}
+
+func isFieldChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.' || c == '-'
+}
+
+// exprParser is a recursive descent parser of the grammar:
+//
+//	or   = and { "or" and }
+//	and  = not { "and" not }
+//	not  = "not" not | atom
+//	atom = "(" or ")" | filter
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+// ParseExpr parses a --where expression, e.g.
+// `(tag="<none>" or created>3m) and not label.keep`
+func ParseExpr(s string) (Expr, error) {
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token at %d", p.tokens[p.pos].pos)
+	}
+	return e, nil
+}
+
+func (p *exprParser) next(kind int) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next(tokOr) {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.next(tokAnd) {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (Expr, error) {
+	if p.next(tokNot) {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+	return p.parseAtom()
+}
+
+func (p *exprParser) parseAtom() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	switch t.kind {
+	case tokLParen:
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.next(tokRParen) {
+			return nil, fmt.Errorf("missing ) for ( at %d", t.pos)
+		}
+		return e, nil
+	case tokFilter:
+		p.pos++
+		return &filterExpr{t.filter}, nil
+	}
+	return nil, fmt.Errorf("unexpected token at %d", t.pos)
+}
@@ -0,0 +1,69 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestParseExpr(t *testing.T) {
+	cases := map[string]string{
+		`tag="<none>"`:                       `tag="<none>"`,
+		`a=1 or b=2 and c=3`:                 `(a=1 or (b=2 and c=3))`,
+		`(a=1 or b=2) and not c=3`:           `((a=1 or b=2) and not c=3)`,
+		`NOT label.keep AND created>3m`:      `(not label.keep and created>3m)`,
+		`not (name=nginx or name!=web) or x`: `(not (name=nginx or name!=web) or x)`,
+	}
+	for s, expected := range cases {
+		e, err := ParseExpr(s)
+		if err != nil {
+			t.Errorf("parse expression error: %s, err: %s", s, err)
+			continue
+		}
+		if e.String() != expected {
+			t.Errorf("wrong parse %s: %s, expected: %s", s, e, expected)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	for _, s := range []string{"", "(a=1", "a=1 b=2", "a=1 and", `tag="<none>`, "a= and b=1", "a=1)"} {
+		if _, err := ParseExpr(s); err == nil {
+			t.Errorf("should fail to parse: %q", s)
+		}
+	}
+}
+
+func TestMatcherWhere(t *testing.T) {
+	e, err := ParseExpr(`(tag="<none>" or created>3m) and not name=nginx`)
+	if err != nil {
+		t.Fatalf("parse expression error: %s", err)
+	}
+	m, err := NewMatcher(imageFields)
+	if err != nil {
+		t.Fatalf("error when creating matcher: %s", err)
+	}
+	if err = m.Where(e); err != nil {
+		t.Fatalf("error when compiling expression: %s", err)
+	}
+	now := time.Now().Unix()
+	old := time.Now().AddDate(0, -4, 0).Unix()
+	cases := []struct {
+		img      docker.APIImages
+		expected bool
+	}{
+		{docker.APIImages{RepoTags: []string{"<none>:<none>"}, Created: now}, true},
+		{docker.APIImages{RepoTags: []string{"app:v1"}, Created: old}, true},
+		{docker.APIImages{RepoTags: []string{"app:v1"}, Created: now}, false},
+		{docker.APIImages{RepoTags: []string{"nginx:latest"}, Created: old}, false},
+	}
+	for _, c := range cases {
+		if m.Satisfied(&imageResource{APIImages: c.img}) != c.expected {
+			t.Errorf("wrong result of %v created %d, expected: %v", c.img.RepoTags, c.img.Created, c.expected)
+		}
+	}
+	if err = m.Where(&filterExpr{Filter{"unknown=1", "unknown", EQ, "1"}}); err == nil {
+		t.Error("unknown field should fail to compile")
+	}
+}
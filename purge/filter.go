@@ -1,10 +1,27 @@
 package purge
 
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
 type Op string
 
+// InfoProvider exposes the fields of a resource to filters, as described by
+// the Fields of its Purger
 type InfoProvider interface {
-	StringField(f string) string
-	IntField(f string) int64
+	// StringField returns the values of a string field
+	StringField(f string) []string
+
+	// IntField returns the value of an int, time or bool field. ok is false
+	// when the resource has no value, e.g. the exited time of a running container.
+	IntField(f string) (v int64, ok bool)
+
+	// Labels returns the labels of the resource, see labelMatcher
+	Labels() map[string]string
 }
 
 const (
@@ -14,8 +31,20 @@ const (
 	GTE Op = ">="
 	LT Op = "<"
 	GT Op = ">"
+
+	// operators that only apply to strings, see stringMatcher
+	MATCH Op = "~="
+	GLOB Op = "*="
+	PREFIX Op = "^="
+
+	// operators of labels without value, "label.keep" and "!label.keep"
+	EXISTS Op = ""
+	ABSENT Op = "!"
 )
 
+// labelPrefix is the field prefix of label filters, e.g. "label.team=payments"
+const labelPrefix = "label."
+
 type Compare func(one, another interface{}) bool
 
 func EqInt64(first, second interface{}) bool {
@@ -109,6 +138,106 @@ var stringComparator = map[Op]Compare{
 	GT: GtString,
 }
 
+// stringMatcher creates a function that compares strings with the value of f.
+// Besides stringComparator, it supports regular expressions (~=),
+// globs (*=, see globRegexp) and prefixes (^=).
+func stringMatcher(f Filter) (match func(s string) bool, err error) {
+	switch f.Comparator {
+	case MATCH:
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s, reason: %s", f.Source, err)
+		}
+		return re.MatchString, nil
+	case GLOB:
+		re, err := globRegexp(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern: %s, reason: %s", f.Source, err)
+		}
+		return re.MatchString, nil
+	case PREFIX:
+		return func(s string) bool {
+			return strings.HasPrefix(s, f.Value)
+		}, nil
+	}
+	op, ok := stringComparator[f.Comparator]
+	if !ok {
+		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
+		return nil, errors.New(tips)
+	}
+	return func(s string) bool {
+		return op(s, f.Value)
+	}, nil
+}
+
+// globRegexp compiles a glob pattern with the syntax of path.Match, except
+// that * and ? also match /, so that "myregistry/*" matches the nested
+// repositories of myregistry too
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	// path.Match reports malformed patterns, e.g. an unclosed [
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			i++
+			if i < len(pattern) {
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			// classes have the syntax of regexp, path.Match checked that
+			// this one is closed
+			end := i + 1
+			for pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			b.WriteString(pattern[i : end+1])
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// labelMatcher creates a function that checks the label named by the field of
+// f, e.g. "label.team". The label either exists (EXISTS), is absent (ABSENT),
+// or its value is compared with stringMatcher, a missing label having an
+// empty value.
+func labelMatcher(f Filter) (match func(labels map[string]string) bool, err error) {
+	key := strings.TrimPrefix(f.Field, labelPrefix)
+	switch f.Comparator {
+	case EXISTS:
+		return func(labels map[string]string) bool {
+			_, ok := labels[key]
+			return ok
+		}, nil
+	case ABSENT:
+		return func(labels map[string]string) bool {
+			_, ok := labels[key]
+			return !ok
+		}, nil
+	}
+	value, err := stringMatcher(f)
+	if err != nil {
+		return
+	}
+	return func(labels map[string]string) bool {
+		return value(labels[key])
+	}, nil
+}
+
 type Filter struct {
 	Source string
 
@@ -120,8 +249,12 @@ type Filter struct {
 	Value string
 }
 
-// parseFilter uses filterPtn to parse -f argument into Filter instance
+// parseFilter uses filterPtn to parse -f argument into Filter instance.
+// Labels may also be checked without value with labelPtn.
 func parseFilter(s string) (f Filter, err error) {
+	if m := labelPtn.FindStringSubmatch(s); m != nil {
+		return Filter{Source: s, Field: m[2], Comparator: Op(m[1])}, nil
+	}
 	m := filterPtn.FindStringSubmatch(s)
 	if m == nil {
 		return f, Mismatched
@@ -142,3 +275,16 @@ func parseFilter(s string) (f Filter, err error) {
 	}
 	return
 }
+
+// parseFilters parses every filter string with parseFilter
+func parseFilters(ss []string) (filters []Filter, err error) {
+	var f Filter
+	for _, s := range ss {
+		f, err = parseFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return
+}
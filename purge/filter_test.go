@@ -1,6 +1,9 @@
 package purge
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestEqInt64(t *testing.T) {
 	if !EqInt64(int64(1), int64(1)) {
@@ -101,3 +104,142 @@ func TestParseSize(t *testing.T) {
 		t.Errorf("size error: %d, expected: %d", size, 1*g)
 	}
 }
+
+func TestParseFilterMatchOps(t *testing.T) {
+	cases := map[string]Filter{
+		"name~=^app-(web|api)$": {Field: "name", Comparator: MATCH, Value: "^app-(web|api)$"},
+		"name*=myregistry/*":    {Field: "name", Comparator: GLOB, Value: "myregistry/*"},
+		"tag^=v1.":              {Field: "tag", Comparator: PREFIX, Value: "v1."},
+		"size>=500M":            {Field: "size", Comparator: GTE, Value: "500M"},
+	}
+	for s, expected := range cases {
+		f, err := parseFilter(s)
+		if err != nil {
+			t.Errorf("parse filter error: %s", err)
+			continue
+		}
+		if f.Field != expected.Field || f.Comparator != expected.Comparator || f.Value != expected.Value {
+			t.Errorf("wrong parse %s: %+v", s, f)
+		}
+	}
+}
+
+func TestStringMatcher(t *testing.T) {
+	cases := []struct {
+		filter   string
+		s        string
+		expected bool
+	}{
+		{"name~=^app-(web|api)$", "app-web", true},
+		{"name~=^app-(web|api)$", "app-worker", false},
+		{"name*=myregistry/*", "myregistry/app", true},
+		{"name*=myregistry/*", "other/app", false},
+		{"name*=myregistry/*", "myregistry/team/app", true},
+		{"name*=*/app", "myregistry/team/app", true},
+		{"name*=myregistry/?/app", "myregistry/x/app", true},
+		{"name*=app-[^0-9]", "app-x", true},
+		{"name*=app-[^0-9]", "app-1", false},
+		{"name*=app.\\*", "app.*", true},
+		{"name*=app.\\*", "app.x", false},
+		{"name*=app.*", "appx", false},
+		{"tag^=v1.", "v1.2.0", true},
+		{"tag^=v1.", "v2.0", false},
+		{"name=app", "app", true},
+	}
+	for _, c := range cases {
+		f, _ := parseFilter(c.filter)
+		match, err := stringMatcher(f)
+		if err != nil {
+			t.Errorf("create matcher error: %s, err: %s", c.filter, err)
+			continue
+		}
+		if match(c.s) != c.expected {
+			t.Errorf("wrong match of %s against %s, expected: %v", c.filter, c.s, c.expected)
+		}
+	}
+	for _, s := range []string{"name~=(", "name*=["} {
+		f, _ := parseFilter(s)
+		if _, err := stringMatcher(f); err == nil {
+			t.Errorf("should fail to create matcher: %s", s)
+		}
+	}
+}
+
+func TestLabelMatcher(t *testing.T) {
+	labels := map[string]string{"keep": "true", "team": "payments"}
+	cases := map[string]bool{
+		"label.keep":          true,
+		"!label.keep":         false,
+		"label.owner":         false,
+		"!label.owner":        true,
+		"label.team=payments": true,
+		"label.team!=search":  true,
+		"label.team^=pay":     true,
+	}
+	for s, expected := range cases {
+		f, err := parseFilter(s)
+		if err != nil {
+			t.Errorf("parse filter error: %s", err)
+			continue
+		}
+		match, err := labelMatcher(f)
+		if err != nil {
+			t.Errorf("create label matcher error: %s, err: %s", s, err)
+			continue
+		}
+		if match(labels) != expected {
+			t.Errorf("wrong match of %s, expected: %v", s, expected)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]Ago{
+		"1y6m":     {Years: 1, Months: 6},
+		"2m":       {Months: 2},
+		"30M":      {Duration: 30 * time.Minute},
+		"2w3d":     {Days: 17},
+		"6h30min":  {Duration: 6*time.Hour + 30*time.Minute},
+		"1d12h45s": {Days: 1, Duration: 12*time.Hour + 45*time.Second},
+		"P1M2DT3H": {Months: 1, Days: 2, Duration: 3 * time.Hour},
+		"P2W":      {Days: 14},
+		"PT1M":     {Duration: time.Minute},
+		"P1Y2MT3M": {Years: 1, Months: 2, Duration: 3 * time.Minute},
+	} {
+		a, err := parseDuration(s)
+		if err != nil {
+			t.Errorf("parse duration error: %s, err: %s", s, err)
+		} else if *a != want {
+			t.Errorf("duration error: %s, got: %+v, expected: %+v", s, *a, want)
+		}
+	}
+	for _, s := range []string{"", "3", "3x", "1d ", "d3", "1dx2h", "P", "PT", "P1DT", "P1H", "2024-01-01"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("invalid duration should fail: %q", s)
+		}
+	}
+}
+
+func TestAgoTime(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	a := Ago{Months: 1, Days: 1, Duration: 90 * time.Minute}
+	if got := a.Time(now); !got.Equal(time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("wrong time of %+v: %s", a, got)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for s, want := range map[string]time.Time{
+		"2024-01-01":                time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		"2024-01-01 15:04":          time.Date(2024, 1, 1, 15, 4, 0, 0, time.Local),
+		"2024-01-01T15:04:05":       time.Date(2024, 1, 1, 15, 4, 5, 0, time.Local),
+		"2024-01-01T15:04:05+02:00": time.Date(2024, 1, 1, 13, 4, 5, 0, time.UTC),
+	} {
+		if got, ok := parseTimestamp(s); !ok || !got.Equal(want) {
+			t.Errorf("wrong timestamp of %s: %s, %v", s, got, ok)
+		}
+	}
+	if _, ok := parseTimestamp("3d"); ok {
+		t.Error("a duration is not a timestamp")
+	}
+}
@@ -0,0 +1,130 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// dockerUris are the docker hosts of --docker, which can be repeated
+	dockerUris []string
+
+	// inventory is a file of docker hosts, one per line
+	inventory string
+
+	// hosts are the docker hosts of --docker and --inventory, purged one
+	// after another. Empty means the docker of the environment.
+	hosts []string
+
+	// host is the docker host being purged when there are several of them
+	host string
+
+	// hostClients keeps the client of each host for the next runs of the
+	// daemon
+	hostClients = make(map[string]*docker.Client)
+)
+
+// dockerHosts returns the hosts of --docker followed by the ones of the
+// inventory file, without duplicates
+func dockerHosts(uris []string, inventory string) (hosts []string, err error) {
+	all := uris
+	if inventory != "" {
+		listed, err := readInventory(inventory)
+		if err != nil {
+			return nil, err
+		}
+		all = append(append([]string(nil), uris...), listed...)
+	}
+	seen := make(map[string]bool)
+	for _, h := range all {
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	return
+}
+
+// readInventory reads an inventory file. Each line is a docker uri, blank
+// lines and lines starting with # are ignored.
+func readInventory(path string) (hosts []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no docker host in inventory %s", path)
+	}
+	return
+}
+
+// forEachHost runs purge against every host one after another, and sets
+// the host of their summaries. A host that fails does not stop the others,
+// its error is returned with the ones of other hosts after all of them.
+// Hosts left when ctx is done are not purged. With one host or less, purge
+// runs once as is.
+func forEachHost(ctx context.Context, purge func(ctx context.Context) ([]Summary, error)) (summaries []Summary, err error) {
+	if len(hosts) <= 1 {
+		return purge(ctx)
+	}
+	defer func() { dockerUri, host, client = "", "", nil }()
+	var failed []string
+	for _, h := range hosts {
+		if ctx.Err() != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", h, ctx.Err()))
+			continue
+		}
+		dockerUri, host, client = h, h, hostClients[h]
+		reporter.Host(h)
+		done, e := purge(ctx)
+		if client != nil {
+			hostClients[h] = client
+		}
+		for i := range done {
+			done[i].Host = h
+		}
+		summaries = append(summaries, done...)
+		if e != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", h, e))
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d of %d hosts failed:\n%s", len(failed), len(hosts), strings.Join(failed, "\n"))
+	}
+	return
+}
+
+// purgeHosts runs purge with forEachHost and reports the summaries
+func purgeHosts(ctx context.Context, purge func(ctx context.Context) ([]Summary, error)) error {
+	summaries, err := forEachHost(ctx, purge)
+	if err == nil || len(summaries) > 0 {
+		reporter.Summary(summaries...)
+	}
+	return err
+}
+
+// single adapts the purge of one resource type to forEachHost. A failed
+// purge has no summary, unless it was canceled.
+func single(summary Summary, err error) ([]Summary, error) {
+	if err != nil && err != context.Canceled {
+		return nil, err
+	}
+	return []Summary{summary}, err
+}
@@ -0,0 +1,90 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	data := "# build agents\ntcp://agent-1:2376\n\n  tcp://agent-2:2376  \ntcp://local:2375\n"
+	if err = ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := dockerHosts([]string{"tcp://local:2375"}, path)
+	if err != nil {
+		t.Fatalf("read hosts error: %s", err)
+	}
+	if strings.Join(hosts, ",") != "tcp://local:2375,tcp://agent-1:2376,tcp://agent-2:2376" {
+		t.Errorf("wrong hosts: %v", hosts)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("# nothing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dockerHosts(nil, path); err == nil {
+		t.Error("empty inventory should fail")
+	}
+	if _, err = dockerHosts(nil, filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing inventory should fail")
+	}
+}
+
+func TestForEachHost(t *testing.T) {
+	defer func(h []string, r Reporter) { hosts, reporter = h, r }(hosts, reporter)
+	hosts = []string{"tcp://a:2375", "tcp://down:2375", "tcp://b:2375"}
+	reporter = &recordBuffer{}
+
+	var purged []string
+	summaries, err := forEachHost(context.Background(), func(ctx context.Context) ([]Summary, error) {
+		purged = append(purged, dockerUri)
+		if host == "tcp://down:2375" {
+			return nil, errors.New("connection refused")
+		}
+		return []Summary{{Resource: "image", Removed: 1}}, nil
+	})
+	if strings.Join(purged, ",") != strings.Join(hosts, ",") {
+		t.Errorf("wrong purged hosts: %v", purged)
+	}
+	if len(summaries) != 2 || summaries[0].Host != "tcp://a:2375" || summaries[1].Host != "tcp://b:2375" {
+		t.Errorf("wrong summaries: %+v", summaries)
+	}
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 hosts failed") || !strings.Contains(err.Error(), "tcp://down:2375") {
+		t.Errorf("wrong error: %v", err)
+	}
+	if dockerUri != "" || host != "" || client != nil {
+		t.Error("the current host is not reset")
+	}
+
+	// hosts left after a cancel are not purged
+	ctx, cancel := context.WithCancel(context.Background())
+	purged = nil
+	_, err = forEachHost(ctx, func(ctx context.Context) ([]Summary, error) {
+		purged = append(purged, host)
+		cancel()
+		return nil, ctx.Err()
+	})
+	if len(purged) != 1 || err == nil || !strings.Contains(err.Error(), "3 of 3 hosts failed") {
+		t.Errorf("wrong purge after a cancel: %v, %v", purged, err)
+	}
+}
+
+func TestSingle(t *testing.T) {
+	if summaries, err := single(Summary{Resource: "image"}, errors.New("refused")); summaries != nil || err == nil {
+		t.Errorf("a failed purge should have no summary: %v, %v", summaries, err)
+	}
+	summaries, err := single(Summary{Resource: "image", Removed: 2}, context.Canceled)
+	if len(summaries) != 1 || summaries[0].Removed != 2 || err != context.Canceled {
+		t.Errorf("a canceled purge should keep its summary: %v, %v", summaries, err)
+	}
+}
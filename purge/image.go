@@ -1,189 +1,226 @@
 package purge
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/spf13/cobra"
 	"strings"
+	"time"
 )
 
 var cmdImg = &cobra.Command{
-	Use: "image",
+	Use:   "image",
 	Short: "Clean images",
-	Long: "Clean images",
-	RunE: RunCmdImage,
+	Long:  "Clean images",
+	RunE:  RunCmdImage,
 }
 
+// imageFields are the fields of images that filters can compare
+var imageFields = map[string]Field{
+	"created": {Kind: TimeKind},
+	"name":    {Kind: StringKind},
+	"tag":     {Kind: StringKind},
+	"size":    {Kind: IntKind, Parse: parseSize},
+}
 
-type ImgFilter func(img docker.APIImages) bool
+// imageResource adapts an image to Resource
+type imageResource struct {
+	docker.APIImages
 
-type ImageValidator struct {
-	Validators []ImgFilter
+	// unique and shared are the sizes from imageSize
+	unique, shared int64
 }
 
-// Satisfied checks if an image can pass all filters of the validator
-func (i *ImageValidator) Satisfied(img docker.APIImages) bool {
-	if len(i.Validators) == 0 {
-		return false
-	}
-	for _, Func := range i.Validators {
-		if !Func(img) {
-			return false
+func (i *imageResource) StringField(f string) (values []string) {
+	// tags form: [registry[:port]/]repo/name:tag, see imageRepo
+	for _, ref := range i.RepoTags {
+		name := imageRepo(ref)
+		switch {
+		case f == "name":
+			values = append(values, name)
+		case f == "tag" && len(name) < len(ref):
+			values = append(values, ref[len(name)+1:])
 		}
 	}
-	return true
+	return
 }
 
+func (i *imageResource) IntField(f string) (int64, bool) {
+	switch f {
+	case "created":
+		return i.APIImages.Created, true
+	case "size":
+		return i.APIImages.Size, true
+	}
+	return 0, false
+}
 
-func NewImageValidator(filters ...Filter) (iv *ImageValidator, err error) {
-	iv = new(ImageValidator)
-	var filter ImgFilter
-	for _, f := range filters {
-		switch f.Field {
-		case "created":
-			filter, err = ImgCreatedFilter(f)
-		case "name":
-			filter, err = ImgNameFilter(f)
-		case "tag":
-			filter, err = ImgTagFilter(f)
-		case "size":
-			filter, err = ImgSizeFilter(f)
-		}
-		if err != nil {
-			return
+func (i *imageResource) Labels() map[string]string    { return i.APIImages.Labels }
+func (i *imageResource) ID() string                   { return i.APIImages.ID }
+func (i *imageResource) Names() []string              { return i.RepoTags }
+func (i *imageResource) Size() (unique, shared int64) { return i.unique, i.shared }
+func (i *imageResource) Created() time.Time           { return time.Unix(i.APIImages.Created, 0) }
+
+// Status tells if an image is dangling
+func (i *imageResource) Status() string {
+	for _, ref := range i.RepoTags {
+		if !strings.HasPrefix(ref, "<none>") {
+			return ""
 		}
-		iv.Validators = append(iv.Validators, filter)
 	}
-	return
+	return "dangling"
+}
+
+// imagePurger purges images. Images in use or kept by keepImages are never
+// removed, see ImageProtector, and keepLast and the disk target of
+// untilFree or the watermarks limit which images are removed.
+type imagePurger struct {
+	protector *ImageProtector
+	target    *DiskTarget
 }
 
-// ImgCreatedFilter creates a filter that filters image with created timestamp
-func ImgCreatedFilter(f Filter) (filter ImgFilter, err error) {
-	ago, err := parseDuration(f.Value)
+func newImagePurger() Purger {
+	return new(imagePurger)
+}
+
+func (p *imagePurger) Type() string             { return "image" }
+func (p *imagePurger) Fields() map[string]Field { return imageFields }
+
+func (p *imagePurger) List(ctx context.Context, cli *docker.Client) (resources []Resource, err error) {
+	images, err := cli.ListImages(docker.ListImagesOptions{All: true, Context: ctx})
 	if err != nil {
 		return
 	}
-	cmp, ok := int64Comparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
-	}
-	filter = func(img docker.APIImages) bool {
-		return cmp(ago.Timestamp(), img.Created)
+	usage := imageDiskUsage(ctx, cli)
+	for _, img := range images {
+		unique, shared := imageSize(img, usage)
+		resources = append(resources, &imageResource{img, unique, shared})
 	}
 	return
 }
 
-// ImgNameFilter creates a filter that filters image with name
-func ImgNameFilter(f Filter) (filter ImgFilter, err error) {
-	op, ok := stringComparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
-	}
-	filter = func(img docker.APIImages) bool {
-		// tags form: repo/name:tag
-		for _, tags := range img.RepoTags {
-			name := strings.Split(tags, ":")[0]
-			if op(name, f.Value) {
-				return true
-			}
+// Prepare adds the retention of keepLast to the filters, and finds the
+// images in use
+func (p *imagePurger) Prepare(ctx context.Context, cli *docker.Client, resources []Resource, m *Matcher) (err error) {
+	if p.target, err = ParseDiskTarget(untilFree, highWatermark, lowWatermark); err != nil {
+		return
+	}
+	images := make([]docker.APIImages, len(resources))
+	for i, r := range resources {
+		images[i] = r.(*imageResource).APIImages
+	}
+	if keepLast > 0 {
+		candidates, err := RetentionCandidates(images, keepLast, groupBy)
+		if err != nil {
+			return err
 		}
-		return false
+		m.Add(func(r InfoProvider) bool { return candidates[r.(*imageResource).ID()] })
+	}
+	// under disk pressure without filters, every image is a candidate
+	if p.target != nil && m.Empty() {
+		m.Add(func(r InfoProvider) bool { return true })
 	}
+	p.protector, err = newImageProtectorFromDaemon(ctx, cli, images)
 	return
 }
 
-// ImgTagFilter creates a filter that filters image with tag
-func ImgTagFilter(f Filter) (filter ImgFilter, err error) {
-	op, ok := stringComparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
-	}
-	filter = func(img docker.APIImages) bool {
-		// tags form: repo/name:tag
-		for _, tags := range img.RepoTags {
-			parts := strings.Split(tags, ":")
-			if len(parts) < 2 {
-				return false
-			}
-
-			if op(parts[1], f.Value) {
-				return true
-			}
-		}
-		return false
+func (p *imagePurger) Keep(r Resource) string {
+	return p.protector.Protected(r.(*imageResource).APIImages)
+}
+
+// Pressure measures the docker data root against the disk target and ranks
+// candidates oldest and largest first
+func (p *imagePurger) Pressure(ctx context.Context, cli *docker.Client, candidates []Resource) (pressure *diskPressure, err error) {
+	if pressure, err = newDiskPressure(ctx, cli, p.target); pressure != nil {
+		rankResources(candidates)
 	}
 	return
 }
 
-// ImgSizeFilter creates a filter that filters image with size
-func ImgSizeFilter(f Filter) (filter ImgFilter, err error) {
-	size, err := parseSize(f.Value)
-	if err != nil {
-		return
+// Split returns the tags of an image that pass m on their own, as the
+// daemon refuses to remove an image by ID while several tags point at it
+func (p *imagePurger) Split(m *Matcher, r Resource) ([]string, bool) {
+	return matchingTags(m, r.(*imageResource))
+}
+
+// RemovePart untags an image
+func (p *imagePurger) RemovePart(ctx context.Context, cli *docker.Client, r Resource, tag string) error {
+	return cli.RemoveImageExtended(tag, docker.RemoveImageOptions{Force: force, NoPrune: noPrune, Context: ctx})
+}
+
+func (p *imagePurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	return cli.RemoveImageExtended(r.ID(), docker.RemoveImageOptions{Force: force, NoPrune: noPrune, Context: ctx})
+}
+
+// matchingTags returns the tags of an image that pass the matcher on their
+// own, and whether they are all of its tags. Images with one tag or less are
+// not split.
+func matchingTags(m *Matcher, img *imageResource) (tags []string, all bool) {
+	var named []string
+	for _, ref := range img.RepoTags {
+		if !strings.HasPrefix(ref, "<none>") {
+			named = append(named, ref)
+		}
 	}
-	op, ok := int64Comparator[f.Comparator]
-	if !ok {
-		tips := fmt.Sprintf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
-		return  nil, errors.New(tips)
+	if len(named) <= 1 {
+		return named, true
 	}
-	filter = func(img docker.APIImages) bool {
-		return op(img.Size, size)
+	for _, ref := range named {
+		one := *img
+		one.RepoTags = []string{ref}
+		if m.Satisfied(&one) {
+			tags = append(tags, ref)
+		}
 	}
-	return
+	return tags, len(tags) == len(named)
 }
 
 func RunCmdImage(cmd *cobra.Command, args []string) error {
 	var filters []Filter
 	for _, f := range filter {
-		fmt.Println("Filter: ", f)
+		reporter.Filter(f)
 		parsed, err := parseFilter(f)
 		if err != nil {
 			return err
 		}
 		filters = append(filters, parsed)
 	}
-	return RemoveImages(filters...)
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return single(RemoveImages(ctx, filters...))
+	})
 }
 
+// RemoveImages purges images with filters, see imagePurger
+func RemoveImages(ctx context.Context, filters ...Filter) (Summary, error) {
+	return Purge(ctx, newImagePurger(), filters...)
+}
 
-func RemoveImages (filters ...Filter) (err error) {
-	var cli *docker.Client
-	if dockerUri == "" {
-		cli, err = docker.NewClientFromEnv()
-	} else {
-		cli, err = docker.NewClient(dockerUri)
-	}
+// imageDiskUsage returns the disk usage of images by ID, or nil if the
+// daemon can not report it
+func imageDiskUsage(ctx context.Context, cli *docker.Client) map[string]*docker.ImageSummary {
+	du, err := cli.DiskUsage(docker.DiskUsageOptions{Context: ctx})
 	if err != nil {
-		return
+		return nil
 	}
-	iv, err := NewImageValidator(filters...)
-	if err != nil {
-		return
-	}
-	images, err := cli.ListImages(docker.ListImagesOptions{All:true})
-	if err != nil {
-		return
-	}
-	for _, img := range images {
-		if iv.Satisfied(img) {
-			if dryRun {
-				fmt.Println("[DryRun]Removing image:", img.ID, img.RepoTags)
-				continue
-			}
-			er := cli.RemoveImage(img.ID)
-			if er != nil {
-				fmt.Printf("can not remove image %s, reason: %s\n", img.ID, er)
-			}
-			fmt.Println("removed:", img.ID, img.RepoTags)
-		}
+	usage := make(map[string]*docker.ImageSummary, len(du.Images))
+	for _, img := range du.Images {
+		usage[img.ID] = img
 	}
-	return
+	return usage
 }
 
+// imageSize splits the size of an image into the unique part, which is freed
+// by removing the image, and the part shared with other images.
+// Without disk usage, the whole size is taken as unique.
+func imageSize(img docker.APIImages, usage map[string]*docker.ImageSummary) (unique, shared int64) {
+	s, ok := usage[img.ID]
+	// SharedSize is -1 when the daemon did not compute it
+	if !ok || s.SharedSize < 0 {
+		return img.Size, 0
+	}
+	return s.Size - s.SharedSize, s.SharedSize
+}
 
 // byteSize returns the size in Bytes against the given amount and unit
 func ByteSize(amount int64, unit string) (int64, error) {
@@ -200,4 +237,3 @@ func ByteSize(amount int64, unit string) (int64, error) {
 	}
 	return amount * base, nil
 }
-
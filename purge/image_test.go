@@ -2,64 +2,156 @@ package purge
 
 import (
 	"github.com/fsouza/go-dockerclient"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestImgCreatedFilter(t *testing.T) {
+func TestImageCreatedFilter(t *testing.T) {
 	f := Filter{"created>10d", "created", GT, "10d"}
-	fn, err := ImgCreatedFilter(f)
+	m, err := NewMatcher(imageFields, f)
 	if err != nil {
-		t.Error("error when creating filter function", err)
+		t.Error("error when creating matcher", err)
 	}
 	yesterday := time.Now().AddDate(0, 0, -1)
 
 	ctn := docker.APIImages{Created:yesterday.Unix()}
-	ok := fn(ctn)
+	ok := m.Satisfied(&imageResource{APIImages: ctn})
 	if ok {
 		t.Errorf("filter the wrong result. created 1d ago")
 	}
 	ctn.Created = yesterday.AddDate(0,-1, 0).Unix()
-	ok = fn(ctn)
+	ok = m.Satisfied(&imageResource{APIImages: ctn})
 	if !ok {
 		t.Errorf("wrong filter result: created: 1m1d ago")
 	}
+
+	// absolute times compare the time itself
+	m, _ = NewMatcher(imageFields, Filter{"created<2024-01-01", "created", LT, "2024-01-01"})
+	if !m.Satisfied(&imageResource{APIImages: docker.APIImages{Created: time.Date(2023, 12, 31, 0, 0, 0, 0, time.Local).Unix()}}) {
+		t.Errorf("wrong filter result: created before 2024-01-01")
+	}
+	if m.Satisfied(&imageResource{APIImages: ctn}) {
+		t.Errorf("wrong filter result: created after 2024-01-01")
+	}
 }
 
 
-func TestImgSizeFilter(t *testing.T) {
+func TestImageSizeFilter(t *testing.T) {
 	f := Filter{"size<=500M", "size", LTE, "500M"}
-	ft, err := ImgSizeFilter(f)
+	m, err := NewMatcher(imageFields, f)
 	if err != nil {
-		t.Error("error when creating filter function", err)
+		t.Error("error when creating matcher", err)
 	}
 	var size int64 = 490*1024*1024
 	img := docker.APIImages{Size: size}
-	ok := ft(img)
+	ok := m.Satisfied(&imageResource{APIImages: img})
 	if !ok {
 		t.Errorf("should pass filter. filter: %s, actual: %d", f.Source, img.Size)
 	}
 	img.Size *= 2
-	ok = ft(img)
+	ok = m.Satisfied(&imageResource{APIImages: img})
 	if ok {
 		t.Errorf("should not pass filter. filter: %s, actual: %d", f.Source, img.Size)
 	}
 }
 
-func TestImgNameFilter(t *testing.T) {
+func TestImageNameFilter(t *testing.T) {
 	f := Filter{"name=registry.cn-shenzhen.aliyuncs.com/jzdev/back", "name", EQ, "registry.cn-shenzhen.aliyuncs.com/jzdev/back"}
-	ft, err := ImgNameFilter(f)
+	m, err := NewMatcher(imageFields, f)
 	if err != nil {
-		t.Error("error when creating filter function", err)
+		t.Error("error when creating matcher", err)
 	}
 	img := docker.APIImages{RepoTags: []string{"registry.cn-shenzhen.aliyuncs.com/jzdev/back:v0.8.0"}}
-	ok := ft(img)
+	ok := m.Satisfied(&imageResource{APIImages: img})
 	if !ok {
 		t.Errorf("should pass filter. filter: %s, actual: %s", f.Source, img.RepoTags)
 	}
 	img.RepoTags = []string{"nonregistry.cn-shenzhen.aliyuncs.com/jzdev/jzquantback:v0.8.0"}
-	ok = ft(img)
+	ok = m.Satisfied(&imageResource{APIImages: img})
 	if ok {
 		t.Errorf("should not pass filter. filter: %s, actual: %s", f.Source, img.RepoTags)
 	}
 }
+
+func TestImageRegistryPort(t *testing.T) {
+	img := &imageResource{APIImages: docker.APIImages{RepoTags: []string{"localhost:5000/app:v1", "localhost:5000/base"}}}
+	if names := img.StringField("name"); strings.Join(names, ",") != "localhost:5000/app,localhost:5000/base" {
+		t.Errorf("wrong names: %v", names)
+	}
+	if tags := img.StringField("tag"); strings.Join(tags, ",") != "v1" {
+		t.Errorf("wrong tags: %v", tags)
+	}
+	for _, f := range []Filter{
+		{"name=localhost:5000/app", "name", EQ, "localhost:5000/app"},
+		{"name*=localhost:5000/*", "name", GLOB, "localhost:5000/*"},
+		{"tag=v1", "tag", EQ, "v1"},
+	} {
+		m, err := NewMatcher(imageFields, f)
+		if err != nil {
+			t.Fatal("error when creating matcher", err)
+		}
+		if !m.Satisfied(img) {
+			t.Errorf("should pass filter. filter: %s, actual: %s", f.Source, img.RepoTags)
+		}
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	img := docker.APIImages{ID: "sha256:abc", Size: 300}
+	unique, shared := imageSize(img, nil)
+	if unique != 300 || shared != 0 {
+		t.Errorf("size error without disk usage: %d/%d, expected: 300/0", unique, shared)
+	}
+	usage := map[string]*docker.ImageSummary{
+		"sha256:abc": {ID: "sha256:abc", Size: 300, SharedSize: 200},
+	}
+	unique, shared = imageSize(img, usage)
+	if unique != 100 || shared != 200 {
+		t.Errorf("size error with disk usage: %d/%d, expected: 100/200", unique, shared)
+	}
+	usage["sha256:abc"].SharedSize = -1
+	unique, shared = imageSize(img, usage)
+	if unique != 300 || shared != 0 {
+		t.Errorf("size error with unknown shared size: %d/%d, expected: 300/0", unique, shared)
+	}
+}
+
+func TestImageLabelFilter(t *testing.T) {
+	e, err := ParseExpr("label.team=payments and !label.keep")
+	if err != nil {
+		t.Fatalf("parse expression error: %s", err)
+	}
+	m, _ := NewMatcher(imageFields)
+	if err = m.Where(e); err != nil {
+		t.Fatalf("error when compiling expression: %s", err)
+	}
+	img := docker.APIImages{Labels: map[string]string{"team": "payments"}}
+	if !m.Satisfied(&imageResource{APIImages: img}) {
+		t.Errorf("should pass filter. labels: %v", img.Labels)
+	}
+	img.Labels["keep"] = "true"
+	if m.Satisfied(&imageResource{APIImages: img}) {
+		t.Errorf("should not pass filter. labels: %v", img.Labels)
+	}
+}
+
+func TestMatchingTags(t *testing.T) {
+	m, _ := NewMatcher(imageFields, Filter{"tag=old", "tag", EQ, "old"})
+	cases := []struct {
+		tags     []string
+		expected []string
+		all      bool
+	}{
+		{[]string{"app:old"}, []string{"app:old"}, true},
+		{[]string{"app:old", "app:new"}, []string{"app:old"}, false},
+		{[]string{"app:old", "web:old", "<none>:<none>"}, []string{"app:old", "web:old"}, true},
+		{[]string{"app:new", "web:new"}, nil, false},
+	}
+	for _, c := range cases {
+		tags, all := matchingTags(m, &imageResource{APIImages: docker.APIImages{ID: "sha256:abc", RepoTags: c.tags}})
+		if strings.Join(tags, ",") != strings.Join(c.expected, ",") || all != c.all {
+			t.Errorf("wrong matching tags of %v: %v %v, expected: %v %v", c.tags, tags, all, c.expected, c.all)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/spf13/cobra"
+)
+
+var cmdNet = &cobra.Command{
+	Use:   "network",
+	Short: "Purge unused user-defined networks",
+	Long:  "Purge unused user-defined networks. Built-in networks are never removed",
+	RunE:  RunCmdNetwork,
+}
+
+// builtinNetworks are created by the docker daemon itself and never removed
+var builtinNetworks = map[string]bool{
+	"bridge":          true,
+	"host":            true,
+	"none":            true,
+	"ingress":         true,
+	"docker_gwbridge": true,
+}
+
+// networkFields are the fields of networks that filters can compare
+var networkFields = map[string]Field{
+	"name":       {Kind: StringKind},
+	"driver":     {Kind: StringKind},
+	"scope":      {Kind: StringKind},
+	"containers": {Kind: IntKind},
+	"created":    {Kind: TimeKind},
+}
+
+// apiNetwork is a network of the API with its created time, which
+// docker.Network does not decode
+type apiNetwork struct {
+	docker.Network
+	Created time.Time
+}
+
+// networkResource adapts a network to Resource. attached is the number of
+// containers connected to the network.
+type networkResource struct {
+	docker.Network
+	created  time.Time
+	attached int64
+}
+
+func (n *networkResource) StringField(f string) []string {
+	switch f {
+	case "name":
+		return []string{n.Name}
+	case "driver":
+		return []string{n.Driver}
+	case "scope":
+		return []string{n.Scope}
+	}
+	return nil
+}
+
+// IntField returns the created time only if the daemon reports it
+func (n *networkResource) IntField(f string) (int64, bool) {
+	switch f {
+	case "containers":
+		return n.attached, true
+	case "created":
+		return n.created.Unix(), !n.created.IsZero()
+	}
+	return 0, false
+}
+
+func (n *networkResource) Labels() map[string]string    { return n.Network.Labels }
+func (n *networkResource) ID() string                   { return n.Network.ID }
+func (n *networkResource) Names() []string              { return []string{n.Name} }
+func (n *networkResource) Size() (unique, shared int64) { return 0, 0 }
+func (n *networkResource) Created() time.Time           { return n.created }
+func (n *networkResource) Status() string               { return fmt.Sprintf("%d containers", n.attached) }
+
+// networkPurger purges networks. Built-in networks are never removed.
+type networkPurger struct{}
+
+func newNetworkPurger() Purger {
+	return networkPurger{}
+}
+
+func (networkPurger) Type() string             { return "network" }
+func (networkPurger) Fields() map[string]Field { return networkFields }
+
+// List returns networks with the number of attached containers, which the
+// "containers" filter needs
+func (networkPurger) List(ctx context.Context, cli *docker.Client) (resources []Resource, err error) {
+	// the network list of the API does not carry the attached containers
+	containers, err := cli.ListContainers(docker.ListContainersOptions{All: true, Context: ctx})
+	if err != nil {
+		return
+	}
+	attached := make(map[string]int64)
+	for _, ctn := range containers {
+		for _, endpoint := range ctn.Networks.Networks {
+			attached[endpoint.NetworkID]++
+		}
+	}
+	var networks []apiNetwork
+	if err = getJSON(ctx, cli, "/networks", &networks); err != nil {
+		return
+	}
+	for _, net := range networks {
+		resources = append(resources, &networkResource{net.Network, net.Created, attached[net.ID]})
+	}
+	return
+}
+
+func (networkPurger) Keep(r Resource) string {
+	if builtinNetworks[r.(*networkResource).Name] {
+		return "built-in network"
+	}
+	return ""
+}
+
+func (networkPurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	// RemoveNetwork of go-dockerclient takes no context
+	resp, err := apiRequest(ctx, cli, http.MethodDelete, "/networks/"+r.ID())
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func RunCmdNetwork(cmd *cobra.Command, args []string) error {
+	var filters []Filter
+	for _, f := range filter {
+		reporter.Filter(f)
+		parsed, err := parseFilter(f)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, parsed)
+	}
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return single(RemoveNetworks(ctx, filters...))
+	})
+}
+
+// RemoveNetworks purges networks with filters, see networkPurger
+func RemoveNetworks(ctx context.Context, filters ...Filter) (Summary, error) {
+	return Purge(ctx, newNetworkPurger(), filters...)
+}
@@ -0,0 +1,113 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestNetworkContainersFilter(t *testing.T) {
+	f := Filter{"containers=0", "containers", EQ, "0"}
+	m, err := NewMatcher(networkFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if m.Satisfied(&networkResource{Network: docker.Network{ID: "used"}, attached: 2}) {
+		t.Error("should not pass filter. 2 containers attached")
+	}
+	if !m.Satisfied(&networkResource{Network: docker.Network{ID: "orphan"}}) {
+		t.Error("should pass filter. no container attached")
+	}
+}
+
+func TestNetworkPurgerBuiltin(t *testing.T) {
+	p := networkPurger{}
+	if p.Keep(&networkResource{Network: docker.Network{Name: "bridge", Driver: "bridge"}}) == "" {
+		t.Error("built-in network should always be kept")
+	}
+	if p.Keep(&networkResource{Network: docker.Network{Name: "app_default", Driver: "bridge"}}) != "" {
+		t.Error("user-defined network should not be kept")
+	}
+}
+
+func TestNetworkCreatedFilter(t *testing.T) {
+	m, err := NewMatcher(networkFields, Filter{"created>1d", "created", GT, "1d"})
+	if err != nil {
+		t.Fatal("error when creating matcher", err)
+	}
+	if !m.Satisfied(&networkResource{created: time.Now().AddDate(0, 0, -2)}) {
+		t.Error("should pass filter. created 2 days ago")
+	}
+	if m.Satisfied(&networkResource{created: time.Now().Add(-time.Hour)}) {
+		t.Error("should not pass filter. created 1 hour ago")
+	}
+	if m.Satisfied(&networkResource{}) {
+		t.Error("should not pass filter. created time unknown")
+	}
+}
+
+func TestNetworkPurgerList(t *testing.T) {
+	defer func(tls *dockerTLS) { clientTLS = tls }(clientTLS)
+	clientTLS = nil
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.41/networks":
+			fmt.Fprint(w, `[{"Name":"ci","Id":"n1","Created":"2024-01-02T03:04:05.123456789Z","Driver":"bridge"}]`)
+		case "/v1.41/containers/json":
+			fmt.Fprint(w, `[{"Id":"c1","NetworkSettings":{"Networks":{"ci":{"NetworkID":"n1"}}}}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cli, err := newClient(srv.URL, "1.41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := networkPurger{}.List(context.Background(), cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 {
+		t.Fatalf("wrong number of networks: %d", len(resources))
+	}
+	n := resources[0].(*networkResource)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if n.Name != "ci" || n.Driver != "bridge" || !n.created.Equal(created) || n.attached != 1 {
+		t.Errorf("wrong networks: %+v", n)
+	}
+}
+
+func TestNetworkPurgerRemove(t *testing.T) {
+	defer func(tls *dockerTLS) { clientTLS = tls }(clientTLS)
+	clientTLS = nil
+	var removed string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.NotFound(w, r)
+			return
+		}
+		removed = r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	cli, err := newClient(srv.URL, "1.41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &networkResource{Network: docker.Network{ID: "n1"}}
+	if err = (networkPurger{}).Remove(context.Background(), cli, n); err != nil || removed != "/v1.41/networks/n1" {
+		t.Errorf("wrong removal: %s, %v", removed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = (networkPurger{}).Remove(ctx, cli, n); err == nil {
+		t.Error("a canceled removal should fail")
+	}
+}
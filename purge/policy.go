@@ -0,0 +1,250 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var cmdApply = &cobra.Command{
+	Use:   "apply",
+	Short: "Purge resources with the rules of a policy file",
+	Long: "Purge resources with the rules of a policy file. " +
+		"All rules are checked first, nothing is removed if any rule is invalid",
+	RunE: RunCmdApply,
+}
+
+// policyFile is the path of the policy file of the apply command
+var policyFile string
+
+// Policy is a set of purge rules, run in order
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule purges one type of resource, like a dkp command with its flags
+type Rule struct {
+	Name     string `yaml:"name"`
+	Resource string `yaml:"resource"`
+
+	// Filters are ANDed like repeated -f
+	Filters []string `yaml:"filters"`
+
+	// Where is an expression like --where
+	Where string `yaml:"where"`
+
+	// KeepLast and GroupBy are the retention of images, like --keep-last
+	KeepLast int    `yaml:"keep_last"`
+	GroupBy  string `yaml:"group_by"`
+
+	// Keep is the name patterns of images that are never removed, like --keep
+	Keep []string `yaml:"keep"`
+
+	// UntilFree and the watermarks only remove images under disk pressure,
+	// like --until-free, --high-watermark and --low-watermark
+	UntilFree     string `yaml:"until_free"`
+	HighWatermark string `yaml:"high_watermark"`
+	LowWatermark  string `yaml:"low_watermark"`
+
+	// Force allows removing resources that the daemon refuses to remove,
+	// like --force
+	Force bool `yaml:"force"`
+
+	// NoPrune keeps the untagged parents of removed images, like --no-prune
+	NoPrune bool `yaml:"no_prune"`
+
+	// Volumes, Stop and StopTimeout apply to containers, like --volumes,
+	// --stop and --stop-timeout
+	Volumes     bool          `yaml:"volumes"`
+	Stop        bool          `yaml:"stop"`
+	StopTimeout time.Duration `yaml:"stop_timeout"`
+
+	filters   []Filter
+	whereExpr Expr
+}
+
+// LoadPolicy reads a policy file and checks all its rules
+func LoadPolicy(path string) (p *Policy, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses a policy and checks all its rules
+func ParsePolicy(data []byte) (p *Policy, err error) {
+	p = new(Policy)
+	if err = yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %s", err)
+	}
+	if len(p.Rules) == 0 {
+		return nil, errors.New("invalid policy: no rules")
+	}
+	names := make(map[string]bool)
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("invalid rule #%d: missing name", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("invalid rule %s: duplicated name", r.Name)
+		}
+		names[r.Name] = true
+		if err = r.check(); err != nil {
+			return nil, fmt.Errorf("invalid rule %s: %s", r.Name, err)
+		}
+	}
+	return
+}
+
+// check parses the filters of the rule and compiles them against its resource
+func (r *Rule) check() (err error) {
+	newPurger, ok := purgers[r.Resource]
+	if !ok {
+		return fmt.Errorf("unsupported resource: %q", r.Resource)
+	}
+	if r.filters, err = parseFilters(r.Filters); err != nil {
+		return
+	}
+	if r.Where != "" {
+		if r.whereExpr, err = ParseExpr(r.Where); err != nil {
+			return
+		}
+	}
+	pressure := r.UntilFree != "" || r.HighWatermark != "" || r.LowWatermark != ""
+	if len(r.filters) == 0 && r.whereExpr == nil && r.KeepLast == 0 && !pressure {
+		return errors.New("no filters, the rule would remove nothing")
+	}
+	if r.Resource != "image" && (r.KeepLast != 0 || r.GroupBy != "" || len(r.Keep) > 0 || pressure) {
+		return errors.New("keep_last, group_by, keep, until_free and watermarks only apply to images")
+	}
+	if r.KeepLast < 0 {
+		return errors.New("keep_last can not be negative")
+	}
+	if r.Resource != "image" && r.NoPrune {
+		return errors.New("no_prune only applies to images")
+	}
+	if r.Resource != "image" && r.Resource != "container" && r.Force {
+		return errors.New("force only applies to images and containers")
+	}
+	if r.Resource != "container" && (r.Volumes || r.Stop || r.StopTimeout != 0) {
+		return errors.New("volumes, stop and stop_timeout only apply to containers")
+	}
+	if r.StopTimeout < 0 {
+		return errors.New("stop_timeout can not be negative")
+	}
+	m, err := NewMatcher(newPurger().Fields(), r.filters...)
+	if err == nil && r.whereExpr != nil {
+		err = m.Where(r.whereExpr)
+	}
+	if err == nil && r.Resource == "image" {
+		if _, err = NewImageProtector(r.Keep); err != nil {
+			return
+		}
+		if r.KeepLast > 0 {
+			if _, err = RetentionCandidates(nil, r.KeepLast, r.groupBy()); err != nil {
+				return
+			}
+		}
+		_, err = ParseDiskTarget(r.UntilFree, r.HighWatermark, r.LowWatermark)
+	}
+	return
+}
+
+func (r *Rule) groupBy() string {
+	if r.GroupBy == "" {
+		return "repo"
+	}
+	return r.GroupBy
+}
+
+func (r *Rule) stopTimeout() time.Duration {
+	if r.StopTimeout == 0 {
+		return 10 * time.Second
+	}
+	return r.StopTimeout
+}
+
+// ruleOptions are the options of the command flags that a rule sets
+type ruleOptions struct {
+	where                                  string
+	whereExpr                              Expr
+	keepImages                             []string
+	keepLast                               int
+	groupBy                                string
+	untilFree, highWatermark, lowWatermark string
+	force, noPrune                         bool
+	removeVolumes, stopRunning             bool
+	stopTimeout                            time.Duration
+}
+
+// flagOptions returns the options currently set by the command flags
+func flagOptions() ruleOptions {
+	return ruleOptions{
+		where, whereExpr, keepImages, keepLast, groupBy,
+		untilFree, highWatermark, lowWatermark,
+		force, noPrune, removeVolumes, stopRunning, stopTimeout,
+	}
+}
+
+// set sets the options of the command flags to o
+func (o ruleOptions) set() {
+	where, whereExpr = o.where, o.whereExpr
+	keepImages, keepLast, groupBy = o.keepImages, o.keepLast, o.groupBy
+	untilFree, highWatermark, lowWatermark = o.untilFree, o.highWatermark, o.lowWatermark
+	force, noPrune = o.force, o.noPrune
+	removeVolumes, stopRunning, stopTimeout = o.removeVolumes, o.stopRunning, o.stopTimeout
+}
+
+// options returns the options of the rule, with the defaults of the flags
+// for the ones not given
+func (r *Rule) options() ruleOptions {
+	return ruleOptions{
+		r.Where, r.whereExpr, r.Keep, r.KeepLast, r.groupBy(),
+		r.UntilFree, r.HighWatermark, r.LowWatermark,
+		r.Force, r.NoPrune, r.Volumes, r.Stop, r.stopTimeout(),
+	}
+}
+
+// Apply runs the rule with the options that the command flags would set,
+// and restores the options of the flags once done
+func (r *Rule) Apply(ctx context.Context) (summary Summary, err error) {
+	defer flagOptions().set()
+	r.options().set()
+	summary, err = Purge(ctx, purgers[r.Resource](), r.filters...)
+	summary.Resource = r.Name + ":" + r.Resource
+	return
+}
+
+// Apply runs the rules in order and stops at the first error, returning the
+// summaries of the rules done, and the one of the rule canceled by ctx
+func (p *Policy) Apply(ctx context.Context) (summaries []Summary, err error) {
+	for _, rule := range p.Rules {
+		summary, err := rule.Apply(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				summaries = append(summaries, summary)
+			}
+			return summaries, fmt.Errorf("applying rule %s: %s", rule.Name, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return
+}
+
+func RunCmdApply(cmd *cobra.Command, args []string) error {
+	if len(filter) > 0 || where != "" {
+		return errors.New("-f and --where can not be used with apply, put them in the rules")
+	}
+	policy, err := LoadPolicy(policyFile)
+	if err != nil {
+		return err
+	}
+	return purgeHosts(cmd.Context(), policy.Apply)
+}
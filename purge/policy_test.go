@@ -0,0 +1,108 @@
+package purge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const testPolicy = `
+rules:
+  - name: stopped-ci
+    resource: container
+    filters: ["exited>2d", "name^=ci-"]
+    stop: true
+    stop_timeout: 30s
+  - name: old-images
+    resource: image
+    where: 'tag="<none>" or created>3m'
+    keep_last: 5
+    keep: ["library/*"]
+  - name: orphan-volumes
+    resource: volume
+    filters: ["dangling=true"]
+`
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("parse policy error: %s", err)
+	}
+	if len(p.Rules) != 3 {
+		t.Fatalf("expected 3 rules, got: %d", len(p.Rules))
+	}
+	if len(p.Rules[0].filters) != 2 || p.Rules[1].whereExpr == nil {
+		t.Errorf("filters of rules are not parsed: %+v", p.Rules)
+	}
+	if p.Rules[0].stopTimeout() != 30*time.Second || p.Rules[2].stopTimeout() != 10*time.Second {
+		t.Errorf("wrong stop timeouts: %s %s", p.Rules[0].stopTimeout(), p.Rules[2].stopTimeout())
+	}
+	if p.Rules[1].groupBy() != "repo" {
+		t.Errorf("wrong default group: %s", p.Rules[1].groupBy())
+	}
+}
+
+func TestParsePolicyError(t *testing.T) {
+	cases := map[string]string{
+		"rules: []": "no rules",
+		"rules:\n  - resource: image\n    filters: [tag=x]":                                                                      "missing name",
+		"rules:\n  - name: a\n    resource: pod\n    filters: [name=x]":                                                          "invalid rule a: unsupported resource",
+		"rules:\n  - name: a\n    resource: image":                                                                               "invalid rule a: no filters",
+		"rules:\n  - name: a\n    resource: image\n    filters: [exited>1d]":                                                     "invalid rule a: unsupported filter",
+		"rules:\n  - name: a\n    resource: volume\n    keep_last: 2":                                                            "invalid rule a: keep_last",
+		"rules:\n  - name: a\n    resource: volume\n    filters: [name=x]\n    force: true":                                      "invalid rule a: force",
+		"rules:\n  - name: a\n    resource: image\n    filters: [tag=x]\n    volumes: true":                                      "invalid rule a: volumes",
+		"rules:\n  - name: a\n    resource: image\n    high_watermark: 101%":                                                     "invalid rule a: invalid watermark",
+		"rules:\n  - name: a\n    resource: image\n    where: 'tag=x or'":                                                        "invalid rule a: unexpected end",
+		"rules:\n  - name: a\n    resource: image\n    filter: [tag=x]":                                                          "invalid policy",
+		"rules:\n  - name: a\n    resource: image\n    filters: [tag=x]\n  - name: a\n    resource: image\n    filters: [tag=y]": "invalid rule a: duplicated name",
+	}
+	for policy, expected := range cases {
+		_, err := ParsePolicy([]byte(policy))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("policy %q should fail with %q, err: %v", policy, expected, err)
+		}
+	}
+}
+
+// optionsPurger records the options that each of its purges sees
+type optionsPurger struct {
+	*testPurger
+	seen *[]ruleOptions
+}
+
+func (p optionsPurger) List(ctx context.Context, cli *docker.Client) ([]Resource, error) {
+	*p.seen = append(*p.seen, flagOptions())
+	return nil, nil
+}
+
+func TestPolicyApplyOptions(t *testing.T) {
+	defer func(c *docker.Client, o ruleOptions) { client = c; o.set() }(client, flagOptions())
+	client, _ = docker.NewClient("unix:///nonexistent.sock")
+	var seen []ruleOptions
+	purgers["test"] = func() Purger { return optionsPurger{&testPurger{}, &seen} }
+	defer delete(purgers, "test")
+
+	// the flags given on the command line
+	force, stopTimeout, groupBy = true, time.Minute, "name"
+	p := &Policy{Rules: []Rule{
+		{Name: "first", Resource: "test", Stop: true, StopTimeout: 30 * time.Second, GroupBy: "repo"},
+		// relies on the default stop timeout and group
+		{Name: "second", Resource: "test"},
+	}}
+	if _, err := p.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || !seen[0].stopRunning || seen[0].stopTimeout != 30*time.Second {
+		t.Fatalf("wrong options of the first rule: %+v", seen)
+	}
+	if seen[1].stopRunning || seen[1].stopTimeout != 10*time.Second || seen[1].groupBy != "repo" || seen[1].force {
+		t.Errorf("the second rule should get the defaults: %+v", seen[1])
+	}
+	if !force || stopTimeout != time.Minute || groupBy != "name" || stopRunning {
+		t.Errorf("the options of the flags are not restored: %+v", flagOptions())
+	}
+}
@@ -0,0 +1,102 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// parallel is the number of resources removed at the same time
+	parallel int
+
+	// rate is the maximum number of resources removed per second, 0 is
+	// unlimited
+	rate float64
+)
+
+// checkPool validates --parallel and --rate
+func checkPool() error {
+	if parallel < 1 {
+		return errors.New("--parallel must be at least 1")
+	}
+	if rate < 0 || math.IsNaN(rate) {
+		return errors.New("--rate can not be negative")
+	}
+	// the ticker of runParallel needs an interval of 1ns at least
+	if rate > 0 && float64(time.Second)/rate < 1 {
+		return fmt.Errorf("--rate can not be more than %d per second", time.Second)
+	}
+	return nil
+}
+
+// removal is what happened to one resource. Removals run in parallel keep
+// it until they are reported in order.
+type removal struct {
+	records []Record
+	summary Summary
+}
+
+// reportRemovals reports the records of removals in order and adds them up
+// into summary
+func reportRemovals(removals []removal, summary *Summary) {
+	for _, r := range removals {
+		for _, rec := range r.records {
+			reporter.Record(rec)
+		}
+		summary.add(r.summary)
+	}
+}
+
+// runParallel calls do for every index of n with up to parallel workers,
+// starting at most rate calls per second. It returns once all calls are done.
+// Dry runs are not limited as they do not call the daemon. Once ctx is done,
+// the calls not started yet are left out.
+func runParallel(ctx context.Context, n int, do func(i int)) {
+	workers := parallel
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	var tick <-chan time.Time
+	if rate > 0 && !dryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() == nil {
+					do(i)
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := 0; i < n; i++ {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
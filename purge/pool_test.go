@@ -0,0 +1,102 @@
+package purge
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunParallel(t *testing.T) {
+	defer func(p int, r float64) { parallel, rate = p, r }(parallel, rate)
+	parallel, rate = 4, 0
+
+	var running, most int32
+	done := make([]int, 20)
+	runParallel(context.Background(), len(done), func(i int) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		done[i]++
+		atomic.AddInt32(&running, -1)
+	})
+	for i, d := range done {
+		if d != 1 {
+			t.Errorf("job %d done %d times, expected: 1", i, d)
+		}
+	}
+	if most > 4 {
+		t.Errorf("%d jobs run at the same time, expected at most 4", most)
+	}
+
+	parallel, rate = 4, 100
+	start := time.Now()
+	runParallel(context.Background(), 5, func(i int) {})
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 jobs at 100/s done in %s, expected at least 40ms", elapsed)
+	}
+}
+
+func TestReportRemovals(t *testing.T) {
+	buf := &recordBuffer{}
+	defer func(r Reporter) { reporter = r }(reporter)
+	reporter = buf
+
+	summary := Summary{Resource: "image"}
+	reportRemovals([]removal{
+		{[]Record{{ID: "a"}, {ID: "b"}}, Summary{Untagged: 1, Removed: 1, Reclaimed: 10}},
+		{[]Record{{ID: "c"}}, Summary{Failed: 1}},
+	}, &summary)
+	if len(buf.records) != 3 || buf.records[0].ID != "a" || buf.records[2].ID != "c" {
+		t.Errorf("records are not reported in order: %+v", buf.records)
+	}
+	if summary.Resource != "image" || summary.Removed != 1 || summary.Untagged != 1 || summary.Failed != 1 || summary.Reclaimed != 10 {
+		t.Errorf("wrong summary: %+v", summary)
+	}
+}
+
+// recordBuffer is a Reporter keeping records
+type recordBuffer struct {
+	records []Record
+}
+
+func (b *recordBuffer) Filter(f string)              {}
+func (b *recordBuffer) Host(h string)                {}
+func (b *recordBuffer) Record(r Record)              { b.records = append(b.records, r) }
+func (b *recordBuffer) Summary(summaries ...Summary) {}
+
+func TestRunParallelCanceled(t *testing.T) {
+	defer func(p int, r float64) { parallel, rate = p, r }(parallel, rate)
+	parallel, rate = 1, 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done int32
+	runParallel(ctx, 10, func(i int) {
+		if atomic.AddInt32(&done, 1) == 3 {
+			cancel()
+		}
+	})
+	if done != 3 {
+		t.Errorf("%d jobs done after cancel at the third one, expected: 3", done)
+	}
+}
+
+func TestCheckPool(t *testing.T) {
+	defer func(p int, r float64) { parallel, rate = p, r }(parallel, rate)
+	parallel = 1
+	for _, c := range []struct {
+		rate  float64
+		valid bool
+	}{{0, true}, {0.5, true}, {1e9, true}, {2e9, false}, {math.Inf(1), false}, {-1, false}, {math.NaN(), false}} {
+		rate = c.rate
+		if err := checkPool(); (err == nil) != c.valid {
+			t.Errorf("wrong check of --rate %v: %v", c.rate, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// untilFree is the free space wanted on the docker data root, e.g. "20G"
+	untilFree string
+
+	// highWatermark and lowWatermark are usages of the docker data root, e.g.
+	// "85%". Above the high one, images are removed until the low one.
+	highWatermark string
+	lowWatermark  string
+)
+
+// DiskUsage is the usage of a file system in Bytes
+type DiskUsage struct {
+	Total int64
+	Free  int64
+}
+
+// DiskTarget decides when and how much space must be freed
+type DiskTarget struct {
+	// free is the wanted free space, 0 when watermarks are used
+	free int64
+
+	// high and low are percentages of used space
+	high, low float64
+}
+
+// ParseDiskTarget parses --until-free or --high-watermark with an optional
+// --low-watermark, which defaults to the high one. It returns nil if none
+// of them is given.
+func ParseDiskTarget(untilFree, high, low string) (t *DiskTarget, err error) {
+	switch {
+	case untilFree == "" && high == "" && low == "":
+		return nil, nil
+	case untilFree != "" && (high != "" || low != ""):
+		return nil, errors.New("--until-free can not be used with watermarks")
+	case untilFree != "":
+		free, err := parseSize(untilFree)
+		if err != nil || free <= 0 {
+			return nil, fmt.Errorf("invalid free space: %s", untilFree)
+		}
+		return &DiskTarget{free: free}, nil
+	case high == "":
+		return nil, errors.New("--low-watermark requires --high-watermark")
+	}
+	t = new(DiskTarget)
+	if t.high, err = parsePercent(high); err != nil {
+		return nil, err
+	}
+	t.low = t.high
+	if low != "" {
+		if t.low, err = parsePercent(low); err != nil {
+			return nil, err
+		}
+	}
+	if t.low > t.high {
+		return nil, fmt.Errorf("low watermark %s is above high watermark %s", low, high)
+	}
+	return
+}
+
+// parsePercent parses strings like "85%" or "85"
+func parsePercent(s string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("invalid watermark: %s", s)
+	}
+	return p, nil
+}
+
+// Goal returns the free space to reach, and whether the usage is under
+// pressure at all
+func (t *DiskTarget) Goal(u DiskUsage) (free int64, pressure bool) {
+	if t.free > 0 {
+		return t.free, u.Free < t.free
+	}
+	used := float64(u.Total - u.Free)
+	free = u.Total - int64(float64(u.Total)*t.low/100)
+	return free, used > float64(u.Total)*t.high/100
+}
+
+// String is the target as given on the command line
+func (t *DiskTarget) String() string {
+	if t.free > 0 {
+		return HumanSize(t.free) + " free"
+	}
+	return fmt.Sprintf("usage %g%%-%g%%", t.low, t.high)
+}
+
+// diskPressure tracks the free space of the docker data root against the
+// goal while images are removed
+type diskPressure struct {
+	goal int64
+	free int64
+
+	// target is the DiskTarget as given on the command line
+	target string
+
+	// stat measures the data root again after removals, nil in dry run
+	// where the free space is estimated by the sizes of removed images
+	stat func() (DiskUsage, error)
+}
+
+// newDiskPressure measures the data root of the daemon against the target.
+// It returns nil when there is no target. The data root is measured on the
+// local file system, so the daemon must be reached by its local socket.
+func newDiskPressure(ctx context.Context, cli *docker.Client, target *DiskTarget) (p *diskPressure, err error) {
+	if target == nil {
+		return nil, nil
+	}
+	if !isLocalEndpoint(cli.Endpoint()) {
+		return nil, fmt.Errorf("--until-free and watermarks need the local docker socket, not %s", cli.Endpoint())
+	}
+	// Info of go-dockerclient takes no context
+	var info docker.DockerInfo
+	if err = getJSON(ctx, cli, "/info", &info); err != nil {
+		return
+	}
+	stat := func() (DiskUsage, error) {
+		return statDisk(info.DockerRootDir)
+	}
+	u, err := stat()
+	if err != nil {
+		return nil, fmt.Errorf("measuring docker data root %s: %s", info.DockerRootDir, err)
+	}
+	p = &diskPressure{free: u.Free, target: target.String()}
+	if !dryRun {
+		p.stat = stat
+	}
+	goal, pressure := target.Goal(u)
+	if pressure {
+		p.goal = goal
+	}
+	return
+}
+
+// relieved tells if enough space is free, never without a target
+func (p *diskPressure) relieved() bool {
+	return p != nil && p.free >= p.goal
+}
+
+// planned returns how many of the ranked candidates are expected to relieve
+// the pressure with their sizes, none if there is no pressure
+func (p *diskPressure) planned(candidates []Resource) int {
+	free := p.free
+	for i, r := range candidates {
+		if free >= p.goal {
+			return i
+		}
+		unique, _ := r.Size()
+		free += unique
+	}
+	return len(candidates)
+}
+
+// freed updates the free space after an image of size is removed
+func (p *diskPressure) freed(size int64) error {
+	if p == nil {
+		return nil
+	}
+	if p.stat == nil {
+		p.free += size
+		return nil
+	}
+	u, err := p.stat()
+	if err != nil {
+		return err
+	}
+	p.free = u.Free
+	return nil
+}
+
+// isLocalEndpoint tells if a docker endpoint is a unix socket or a named
+// pipe, which are only reached from the docker host itself
+func isLocalEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "unix://") || strings.HasPrefix(endpoint, "npipe://")
+}
+
+// rankResources sorts resources to remove under disk pressure, oldest first
+// and the largest first among resources of the same age
+func rankResources(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		ci, cj := resources[i].Created(), resources[j].Created()
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		si, _ := resources[i].Size()
+		sj, _ := resources[j].Size()
+		return si > sj
+	})
+}
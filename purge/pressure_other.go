@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package purge
+
+import "errors"
+
+// statDisk is not supported on this platform
+func statDisk(path string) (u DiskUsage, err error) {
+	return u, errors.New("measuring disk usage is not supported on this platform")
+}
@@ -0,0 +1,130 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestParseDiskTarget(t *testing.T) {
+	const g = int64(1 << 30)
+	cases := []struct {
+		untilFree, high, low string
+		usage                DiskUsage
+		goal                 int64
+		pressure             bool
+	}{
+		{"20G", "", "", DiskUsage{100 * g, 10 * g}, 20 * g, true},
+		{"20G", "", "", DiskUsage{100 * g, 30 * g}, 20 * g, false},
+		{"", "85%", "70%", DiskUsage{100 * g, 10 * g}, 30 * g, true},
+		{"", "85%", "70%", DiskUsage{100 * g, 20 * g}, 30 * g, false},
+		{"", "85", "", DiskUsage{100 * g, 10 * g}, 15 * g, true},
+	}
+	for _, c := range cases {
+		target, err := ParseDiskTarget(c.untilFree, c.high, c.low)
+		if err != nil {
+			t.Errorf("parse target error: %q %q %q, err: %s", c.untilFree, c.high, c.low, err)
+			continue
+		}
+		goal, pressure := target.Goal(c.usage)
+		if goal != c.goal || pressure != c.pressure {
+			t.Errorf("wrong goal of %s with %+v: %d %v, expected: %d %v",
+				target, c.usage, goal, pressure, c.goal, c.pressure)
+		}
+	}
+	if target, err := ParseDiskTarget("", "", ""); target != nil || err != nil {
+		t.Errorf("no target expected, got: %v, err: %v", target, err)
+	}
+	for _, c := range [][3]string{
+		{"20G", "85%", ""},
+		{"", "", "70%"},
+		{"", "70%", "85%"},
+		{"", "185%", ""},
+		{"lots", "", ""},
+	} {
+		if _, err := ParseDiskTarget(c[0], c[1], c[2]); err == nil {
+			t.Errorf("should fail to parse target: %q", c)
+		}
+	}
+}
+
+func TestSetupDiskTarget(t *testing.T) {
+	defer func(free string, r Reporter) { untilFree, reporter = free, r }(untilFree, reporter)
+	untilFree = "20X"
+	if err := setup(cmdImg, nil); err == nil {
+		t.Error("an invalid --until-free should fail the setup")
+	}
+}
+
+func TestDiskPressure(t *testing.T) {
+	var p *diskPressure
+	if p.relieved() || p.freed(1) != nil {
+		t.Error("no target should never be relieved")
+	}
+	p = &diskPressure{goal: 10, free: 4}
+	for _, size := range []int64{3, 2} {
+		if p.relieved() {
+			t.Fatalf("relieved too early at %d free", p.free)
+		}
+		p.freed(size)
+	}
+	if p.relieved() {
+		t.Fatalf("relieved too early at %d free", p.free)
+	}
+	p.freed(1)
+	if !p.relieved() {
+		t.Errorf("should be relieved at %d free", p.free)
+	}
+}
+
+func TestDiskPressurePlanned(t *testing.T) {
+	candidates := []Resource{&testResource{size: 3}, &testResource{size: 2}, &testResource{size: 4}, &testResource{size: 1}}
+	if n := (&diskPressure{goal: 10, free: 4}).planned(candidates); n != 3 {
+		t.Errorf("wrong number of planned candidates: %d", n)
+	}
+	if n := (&diskPressure{goal: 0, free: 4}).planned(candidates); n != 0 {
+		t.Errorf("no candidate should be planned without pressure: %d", n)
+	}
+	if n := (&diskPressure{goal: 100, free: 4}).planned(candidates); n != 4 {
+		t.Errorf("all candidates should be planned: %d", n)
+	}
+}
+
+func TestRankResources(t *testing.T) {
+	resources := []Resource{
+		&imageResource{docker.APIImages{ID: "new", Created: 30}, 100, 0},
+		&imageResource{docker.APIImages{ID: "old-small", Created: 10}, 1, 0},
+		&imageResource{docker.APIImages{ID: "mid", Created: 20}, 5, 0},
+		&imageResource{docker.APIImages{ID: "old-large", Created: 10}, 50, 0},
+	}
+	rankResources(resources)
+	for i, id := range []string{"old-large", "old-small", "mid", "new"} {
+		if resources[i].ID() != id {
+			t.Errorf("wrong image at %d: %s, expected: %s", i, resources[i].ID(), id)
+		}
+	}
+}
+
+func TestDiskPressureRemote(t *testing.T) {
+	target, _ := ParseDiskTarget("20G", "", "")
+	for _, endpoint := range []string{"tcp://build:2376", "http://docker"} {
+		cli, err := docker.NewClient(endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = newDiskPressure(context.Background(), cli, target); err == nil {
+			t.Errorf("disk target should be rejected for %s", endpoint)
+		}
+	}
+	for endpoint, local := range map[string]bool{
+		"unix:///var/run/docker.sock":    true,
+		"npipe:////./pipe/docker_engine": true,
+		"tcp://localhost:2375":           false,
+		"ssh://ci@agent-1":               false,
+	} {
+		if isLocalEndpoint(endpoint) != local {
+			t.Errorf("wrong locality of %s", endpoint)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package purge
+
+import "syscall"
+
+// statDisk measures the file system of path
+func statDisk(path string) (u DiskUsage, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return
+	}
+	u.Total = int64(st.Blocks) * int64(st.Bsize)
+	u.Free = int64(st.Bavail) * int64(st.Bsize)
+	return
+}
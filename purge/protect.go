@@ -0,0 +1,186 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// keepImages stores name patterns of images that are never removed
+var keepImages []string
+
+// imageIndex resolves image references, as used by containers and services,
+// to image IDs
+type imageIndex struct {
+	// byRef maps "repo:tag", "repo@digest" and IDs to image IDs
+	byRef map[string]string
+}
+
+func newImageIndex(images []docker.APIImages) imageIndex {
+	idx := imageIndex{byRef: make(map[string]string)}
+	for _, img := range images {
+		idx.byRef[img.ID] = img.ID
+		idx.byRef[strings.TrimPrefix(img.ID, "sha256:")] = img.ID
+		// dangling images are listed as "<none>:<none>" and "<none>@<none>"
+		for _, ref := range img.RepoTags {
+			if !strings.HasPrefix(ref, "<none>") {
+				idx.byRef[ref] = img.ID
+			}
+		}
+		for _, ref := range img.RepoDigests {
+			if !strings.HasPrefix(ref, "<none>") {
+				idx.byRef[ref] = img.ID
+			}
+		}
+	}
+	return idx
+}
+
+// resolve returns the ID of the image that ref points to, or "" if unknown.
+// ref may be "repo", "repo:tag", "repo:tag@digest", "repo@digest" or an ID.
+func (idx imageIndex) resolve(ref string) string {
+	if id, ok := idx.byRef[ref]; ok {
+		return id
+	}
+	// service specs pin images as "repo:tag@digest"
+	if at := strings.Index(ref, "@"); at >= 0 {
+		if id, ok := idx.byRef[imageRepo(ref[:at])+ref[at:]]; ok {
+			return id
+		}
+		ref = ref[:at]
+	}
+	if !strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		ref += ":latest"
+	}
+	return idx.byRef[ref]
+}
+
+// imageRepo strips the tag from a "repo:tag" reference. A colon before the
+// last slash belongs to the registry port, e.g. "localhost:5000/app".
+func imageRepo(ref string) string {
+	colon := strings.LastIndex(ref, ":")
+	if colon > strings.LastIndex(ref, "/") {
+		return ref[:colon]
+	}
+	return ref
+}
+
+// ImageProtector decides which images must not be removed whatever the
+// filters say: images in use and images kept by name patterns
+type ImageProtector struct {
+	keep []string
+
+	// usedBy maps image IDs to what uses them
+	usedBy map[string][]string
+}
+
+// NewImageProtector creates a protector keeping images whose name, with or
+// without tag, matches any of the glob patterns in keep
+func NewImageProtector(keep []string) (p *ImageProtector, err error) {
+	for _, pattern := range keep {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid keep pattern: %s, reason: %s", pattern, err)
+		}
+	}
+	return &ImageProtector{keep: keep, usedBy: make(map[string][]string)}, nil
+}
+
+// apiContainer is a listed container with the ID of its image, which
+// go-dockerclient does not decode
+type apiContainer struct {
+	docker.APIContainers
+	ImageID string
+}
+
+// AddContainers protects the images of containers, running or not. The
+// image of a container is its image ID, its image reference may have been
+// tagged on another image since it was created.
+func (p *ImageProtector) AddContainers(idx imageIndex, containers []apiContainer) {
+	for _, ctn := range containers {
+		id := ctn.ImageID
+		if id == "" {
+			id = idx.resolve(ctn.Image)
+		}
+		if id == "" {
+			continue
+		}
+		state := ctn.State
+		if state == "" {
+			state = "stopped"
+		}
+		name := ctn.ID
+		if len(ctn.Names) > 0 {
+			name = strings.TrimPrefix(ctn.Names[0], "/")
+		}
+		p.usedBy[id] = append(p.usedBy[id], fmt.Sprintf("%s container %s", state, name))
+	}
+}
+
+// AddServices protects the images in the specs of services
+func (p *ImageProtector) AddServices(idx imageIndex, services []swarm.Service) {
+	for _, svc := range services {
+		spec := svc.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			continue
+		}
+		id := idx.resolve(spec.Image)
+		if id == "" {
+			continue
+		}
+		p.usedBy[id] = append(p.usedBy[id], fmt.Sprintf("service %s", svc.Spec.Name))
+	}
+}
+
+// Protected returns why an image must be kept, or "" if it can be removed
+func (p *ImageProtector) Protected(img docker.APIImages) string {
+	if used := p.usedBy[img.ID]; len(used) > 0 {
+		return "used by " + strings.Join(used, ", ")
+	}
+	for _, ref := range img.RepoTags {
+		for _, pattern := range p.keep {
+			if ok, _ := path.Match(pattern, ref); ok {
+				return "kept by " + pattern
+			}
+			if ok, _ := path.Match(pattern, imageRepo(ref)); ok {
+				return "kept by " + pattern
+			}
+		}
+	}
+	return ""
+}
+
+// newImageProtectorFromDaemon creates a protector with keepImages, and the
+// usage of images by containers and, on swarm managers, services
+func newImageProtectorFromDaemon(ctx context.Context, cli *docker.Client, images []docker.APIImages) (p *ImageProtector, err error) {
+	p, err = NewImageProtector(keepImages)
+	if err != nil {
+		return
+	}
+	idx := newImageIndex(images)
+	var containers []apiContainer
+	if err = getJSON(ctx, cli, "/containers/json?all=1", &containers); err != nil {
+		return
+	}
+	p.AddContainers(idx, containers)
+	services, err := cli.ListServices(docker.ListServicesOptions{Context: ctx})
+	if isNotSwarmManager(err) {
+		return p, nil
+	}
+	if err != nil {
+		return
+	}
+	p.AddServices(idx, services)
+	return
+}
+
+// isNotSwarmManager tells if err is the answer of a docker that is not a
+// swarm manager, which can not list services
+func isNotSwarmManager(err error) bool {
+	e, ok := err.(*docker.Error)
+	return ok && e.Status == http.StatusServiceUnavailable
+}
@@ -0,0 +1,107 @@
+package purge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/fsouza/go-dockerclient"
+)
+
+var protectImages = []docker.APIImages{
+	{ID: "sha256:nginx", RepoTags: []string{"nginx:latest"}},
+	{ID: "sha256:app", RepoTags: []string{"localhost:5000/app:v1"}, RepoDigests: []string{"localhost:5000/app@sha256:d1"}},
+	{ID: "sha256:base", RepoTags: []string{"library/base:3.9"}},
+	{ID: "sha256:old", RepoTags: []string{"<none>:<none>"}},
+	{ID: "sha256:ci", RepoTags: []string{"ci:42"}},
+}
+
+func TestImageIndexResolve(t *testing.T) {
+	idx := newImageIndex(protectImages)
+	cases := map[string]string{
+		"nginx":                           "sha256:nginx",
+		"nginx:latest":                    "sha256:nginx",
+		"localhost:5000/app:v1":           "sha256:app",
+		"localhost:5000/app:v1@sha256:d1": "sha256:app",
+		"localhost:5000/app:v2@sha256:d1": "sha256:app",
+		"sha256:old":                      "sha256:old",
+		"old":                             "sha256:old",
+		"missing":                         "",
+		"<none>:<none>":                   "",
+		"localhost:5000/app":              "",
+		"localhost:5000/app@sha256:other": "",
+	}
+	for ref, expected := range cases {
+		if actual := idx.resolve(ref); actual != expected {
+			t.Errorf("wrong image of %s: %s, expected: %s", ref, actual, expected)
+		}
+	}
+}
+
+func TestImageProtector(t *testing.T) {
+	p, err := NewImageProtector([]string{"library/*"})
+	if err != nil {
+		t.Fatalf("error when creating protector: %s", err)
+	}
+	idx := newImageIndex(protectImages)
+	p.AddContainers(idx, []apiContainer{
+		{docker.APIContainers{ID: "c1", Names: []string{"/web"}, Image: "nginx", State: "running"}, ""},
+		// nginx was pulled again after the container was created
+		{docker.APIContainers{ID: "c2", Names: []string{"/job"}, Image: "nginx", State: "exited"}, "sha256:old"},
+	})
+	svc := swarm.Service{}
+	svc.Spec.Name = "api"
+	svc.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "localhost:5000/app:v1@sha256:d1"}
+	p.AddServices(idx, []swarm.Service{svc})
+
+	reasons := map[string]string{
+		"sha256:nginx": "used by running container web",
+		"sha256:old":   "used by exited container job",
+		"sha256:app":   "used by service api",
+		"sha256:base":  "kept by library/*",
+		"sha256:ci":    "",
+	}
+	for _, img := range protectImages {
+		if actual := p.Protected(img); actual != reasons[img.ID] {
+			t.Errorf("wrong protection of %s: %q, expected: %q", img.ID, actual, reasons[img.ID])
+		}
+	}
+	if _, err = NewImageProtector([]string{"["}); err == nil || !strings.Contains(err.Error(), "invalid keep pattern") {
+		t.Errorf("bad pattern should be rejected, err: %v", err)
+	}
+}
+
+func TestImageProtectorFromDaemon(t *testing.T) {
+	defer func(tls *dockerTLS) { clientTLS = tls }(clientTLS)
+	clientTLS = nil
+	for status, fails := range map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusInternalServerError: true,
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/containers/json":
+				w.Write([]byte(`[{"Id":"c1","Image":"nginx","ImageID":"sha256:old"}]`))
+			case "/services":
+				w.WriteHeader(status)
+				w.Write([]byte("[]"))
+			}
+		}))
+		cli, err := newClient(srv.URL, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := newImageProtectorFromDaemon(context.Background(), cli, protectImages)
+		srv.Close()
+		if (err != nil) != fails {
+			t.Errorf("wrong error when listing services fails with %d: %v", status, err)
+		}
+		if err == nil && p.Protected(protectImages[3]) == "" {
+			t.Error("the image of the container should be protected by its ID")
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package purge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	actionRemoved = "removed"
+	actionDryRun  = "dry-run"
+	actionFailed  = "failed"
+	actionSkipped = "skipped"
+
+	// untagging removes one tag of an image that has others
+	actionUntagged    = "untagged"
+	actionDryRunUntag = "dry-run-untag"
+)
+
+// Record describes what happened to one resource that matched the filters
+type Record struct {
+	// Host is the docker host of the resource when several hosts are purged
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+
+	Resource string   `json:"resource" yaml:"resource"`
+	ID       string   `json:"id" yaml:"id"`
+	Names    []string `json:"names,omitempty" yaml:"names,omitempty"`
+	Size     int64    `json:"size" yaml:"size"`
+	Filters  []string `json:"filters,omitempty" yaml:"filters,omitempty"`
+	Action   string   `json:"action" yaml:"action"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+
+	// Reason tells why a matched resource is skipped
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// Reporter outputs the result of purges. All purge commands print through it.
+type Reporter interface {
+	// Filter reports a filter parsed from CMD
+	Filter(f string)
+
+	// Host reports the docker host whose records follow, when several hosts
+	// are purged
+	Host(h string)
+
+	// Record reports the action taken on a resource
+	Record(r Record)
+
+	// Summary reports the summaries of a run
+	Summary(summaries ...Summary)
+}
+
+// reporter is the Reporter selected by --output
+var reporter Reporter = &tableReporter{w: os.Stdout}
+
+// NewReporter creates a Reporter for the output format, one of
+// "table", "json" or "yaml"
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case "", "table":
+		return &tableReporter{w: w}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(w)}, nil
+	case "yaml":
+		return &yamlReporter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unsupported output format: %s", format)
+}
+
+// newRecord creates a Record of a matched resource with the action derived
+// from dryRun and the removal error
+func newRecord(resource, id string, names []string, size int64, filters []Filter, err error) Record {
+	r := Record{Host: host, Resource: resource, ID: id, Names: names, Size: size, Action: actionRemoved}
+	for _, f := range filters {
+		r.Filters = append(r.Filters, f.Source)
+	}
+	if where != "" {
+		r.Filters = append(r.Filters, where)
+	}
+	if dryRun {
+		r.Action = actionDryRun
+	} else if err != nil {
+		r.Action = actionFailed
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// newSkipRecord creates a Record of a matched resource that is kept for reason
+func newSkipRecord(resource, id string, names []string, size int64, filters []Filter, reason string) Record {
+	r := newRecord(resource, id, names, size, filters, nil)
+	r.Action = actionSkipped
+	r.Reason = reason
+	return r
+}
+
+// newUntagRecord creates a Record of a tag removed from an image
+func newUntagRecord(resource, id, tag string, filters []Filter, err error) Record {
+	r := newRecord(resource, id, []string{tag}, 0, filters, err)
+	switch r.Action {
+	case actionRemoved:
+		r.Action = actionUntagged
+	case actionDryRun:
+		r.Action = actionDryRunUntag
+	}
+	return r
+}
+
+// tableReporter prints human readable lines and a summary table
+type tableReporter struct {
+	w io.Writer
+}
+
+func (t *tableReporter) Filter(f string) {
+	fmt.Fprintln(t.w, "Filter: ", f)
+}
+
+func (t *tableReporter) Host(h string) {
+	fmt.Fprintln(t.w, "Host: ", h)
+}
+
+func (t *tableReporter) Record(r Record) {
+	switch r.Action {
+	case actionDryRun:
+		fmt.Fprintf(t.w, "[DryRun]Removing %s: %s %s\n", r.Resource, r.ID, strings.Join(r.Names, " "))
+	case actionFailed:
+		fmt.Fprintf(t.w, "can not remove %s %s, reason: %s\n", r.Resource, r.ID, r.Error)
+	case actionSkipped:
+		fmt.Fprintf(t.w, "skipped %s %s %s, reason: %s\n", r.Resource, r.ID, strings.Join(r.Names, " "), r.Reason)
+	case actionDryRunUntag:
+		fmt.Fprintf(t.w, "[DryRun]Untagging %s: %s %s\n", r.Resource, r.ID, strings.Join(r.Names, " "))
+	case actionUntagged:
+		fmt.Fprintln(t.w, "untagged:", r.ID, strings.Join(r.Names, " "))
+	default:
+		fmt.Fprintln(t.w, "removed:", r.ID, strings.Join(r.Names, " "))
+	}
+}
+
+// Summary prints a table with one row per resource type and a total row.
+// In a dry run, the table is an estimate of what would be removed.
+func (t *tableReporter) Summary(summaries ...Summary) {
+	if dryRun {
+		fmt.Fprintln(t.w, "[DryRun]Estimated summary:")
+	}
+	w := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	totals := hostTotals(summaries...)
+	if len(totals) == 0 {
+		fmt.Fprintln(w, "RESOURCE\tREMOVED\tUNTAGGED\tSKIPPED\tFAILED\tRECLAIMED\tSHARED")
+		for _, s := range summaries {
+			printSummaryRow(w, s)
+		}
+		printSummaryRow(w, totalSummary(summaries...))
+		w.Flush()
+		return
+	}
+	// with several hosts, rows are grouped by host with a total per host
+	fmt.Fprintln(w, "HOST\tRESOURCE\tREMOVED\tUNTAGGED\tSKIPPED\tFAILED\tRECLAIMED\tSHARED")
+	for _, total := range totals {
+		for _, s := range summaries {
+			if s.Host == total.Host {
+				fmt.Fprintf(w, "%s\t", s.Host)
+				printSummaryRow(w, s)
+			}
+		}
+		fmt.Fprintf(w, "%s\t", total.Host)
+		printSummaryRow(w, total)
+	}
+	fmt.Fprint(w, "all\t")
+	printSummaryRow(w, totalSummary(summaries...))
+	w.Flush()
+}
+
+func printSummaryRow(w io.Writer, s Summary) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+		s.Resource, s.Removed, s.Untagged, s.Skipped, s.Failed, HumanSize(s.Reclaimed), HumanSize(s.Shared))
+}
+
+// summaryReport is the final object of json and yaml output
+type summaryReport struct {
+	DryRun    bool      `json:"dry_run" yaml:"dry_run"`
+	Resources []Summary `json:"resources" yaml:"resources"`
+
+	// Hosts are the totals of each host when several hosts are purged
+	Hosts []Summary `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Total Summary   `json:"total" yaml:"total"`
+}
+
+func newSummaryReport(summaries ...Summary) summaryReport {
+	return summaryReport{dryRun, summaries, hostTotals(summaries...), totalSummary(summaries...)}
+}
+
+// jsonReporter prints one JSON object per line, records first and the
+// summary last
+type jsonReporter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonReporter) Filter(f string) {}
+func (j *jsonReporter) Host(h string)   {}
+
+func (j *jsonReporter) Record(r Record) {
+	j.enc.Encode(struct {
+		Record Record `json:"record"`
+	}{r})
+}
+
+func (j *jsonReporter) Summary(summaries ...Summary) {
+	j.enc.Encode(struct {
+		Summary summaryReport `json:"summary"`
+	}{newSummaryReport(summaries...)})
+}
+
+// yamlReporter prints one YAML document per record and the summary last
+type yamlReporter struct {
+	w io.Writer
+}
+
+func (y *yamlReporter) Filter(f string) {}
+func (y *yamlReporter) Host(h string)   {}
+
+func (y *yamlReporter) Record(r Record) {
+	y.write(struct {
+		Record Record `yaml:"record"`
+	}{r})
+}
+
+func (y *yamlReporter) Summary(summaries ...Summary) {
+	y.write(struct {
+		Summary summaryReport `yaml:"summary"`
+	}{newSummaryReport(summaries...)})
+}
+
+func (y *yamlReporter) write(v interface{}) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(y.w, "---\n%s", out)
+}
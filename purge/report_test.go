@@ -0,0 +1,107 @@
+package purge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewReporter(t *testing.T) {
+	for _, format := range []string{"", "table", "json", "yaml"} {
+		if _, err := NewReporter(format, &bytes.Buffer{}); err != nil {
+			t.Errorf("create reporter error: %s, err: %s", format, err)
+		}
+	}
+	if _, err := NewReporter("xml", &bytes.Buffer{}); err == nil {
+		t.Error("xml should not be supported")
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	filters := []Filter{{"tag=<none>", "tag", EQ, "<none>"}}
+	r := newRecord("image", "sha256:abc", nil, 10, filters, errors.New("conflict"))
+	if r.Action != actionFailed || r.Error != "conflict" {
+		t.Errorf("wrong record of failed removal: %+v", r)
+	}
+	if len(r.Filters) != 1 || r.Filters[0] != "tag=<none>" {
+		t.Errorf("wrong filters of record: %v", r.Filters)
+	}
+	r = newRecord("image", "sha256:abc", nil, 10, filters, nil)
+	if r.Action != actionRemoved || r.Error != "" {
+		t.Errorf("wrong record of removal: %+v", r)
+	}
+}
+
+func TestNewUntagRecord(t *testing.T) {
+	r := newUntagRecord("image", "sha256:abc", "app:old", nil, nil)
+	if r.Action != actionUntagged || len(r.Names) != 1 || r.Names[0] != "app:old" || r.Size != 0 {
+		t.Errorf("wrong record of untag: %+v", r)
+	}
+	r = newUntagRecord("image", "sha256:abc", "app:old", nil, errors.New("conflict"))
+	if r.Action != actionFailed {
+		t.Errorf("wrong record of failed untag: %+v", r)
+	}
+}
+
+func TestJsonReporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rp, _ := NewReporter("json", buf)
+	rp.Filter("tag=<none>")
+	rp.Record(Record{Resource: "image", ID: "sha256:abc", Size: 10, Action: actionRemoved})
+	rp.Summary(Summary{Resource: "image", Removed: 1, Reclaimed: 10})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a record and a summary, got: %q", lines)
+	}
+	var rec struct {
+		Record Record `json:"record"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil || rec.Record.ID != "sha256:abc" {
+		t.Errorf("wrong record line: %s, err: %v", lines[0], err)
+	}
+	var sum struct {
+		Summary summaryReport `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &sum); err != nil || sum.Summary.Total.Reclaimed != 10 {
+		t.Errorf("wrong summary line: %s, err: %v", lines[1], err)
+	}
+}
+
+func TestYamlReporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rp, _ := NewReporter("yaml", buf)
+	rp.Record(Record{Resource: "volume", ID: "orphan", Action: actionDryRun})
+	rp.Summary(Summary{Resource: "volume", Removed: 1})
+	out := buf.String()
+	if strings.Count(out, "---\n") != 2 {
+		t.Errorf("expected two yaml documents, got: %s", out)
+	}
+	if !strings.Contains(out, "action: dry-run") {
+		t.Errorf("missing action in yaml output: %s", out)
+	}
+}
+
+func TestTableReporterHosts(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rp, _ := NewReporter("table", buf)
+	rp.Summary(
+		Summary{Host: "tcp://a:2375", Resource: "image", Removed: 1},
+		Summary{Host: "tcp://b:2375", Resource: "image", Removed: 2},
+		Summary{Host: "tcp://a:2375", Resource: "container", Removed: 3},
+	)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 || !strings.HasPrefix(lines[0], "HOST") {
+		t.Fatalf("wrong table: %q", lines)
+	}
+	for i, prefix := range []string{"tcp://a:2375  image", "tcp://a:2375  container", "tcp://a:2375  total", "tcp://b:2375  image", "tcp://b:2375  total", "all"} {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Errorf("wrong row %d: %q, expected: %s", i+1, lines[i+1], prefix)
+		}
+	}
+	if !strings.Contains(lines[3], "  4  ") || !strings.Contains(lines[6], "  6  ") {
+		t.Errorf("wrong totals: %q", lines)
+	}
+}
@@ -0,0 +1,438 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// FieldKind tells how filters compare the values of a field
+type FieldKind int
+
+const (
+	// StringKind fields are compared with stringMatcher. A filter passes if
+	// any of the values of the field passes.
+	StringKind FieldKind = iota
+
+	// IntKind fields are compared with int64Comparator
+	IntKind
+
+	// TimeKind fields are unix timestamps, compared with how long ago they
+	// are, e.g. "created>3d" means created more than 3 days ago, or with an
+	// absolute time, e.g. "created<2024-01-01" means created before 2024
+	TimeKind
+
+	// BoolKind fields are 0 or 1, compared with "=true", "!=false", etc
+	BoolKind
+)
+
+// Field is the metadata of a field that filters can compare
+type Field struct {
+	Kind FieldKind
+
+	// Parse parses the value of filters on IntKind fields, e.g. parseSize.
+	// Values are plain integers by default.
+	Parse func(value string) (int64, error)
+}
+
+// Resource is one docker resource as seen by Purge. Its fields are exposed
+// through InfoProvider, as described by the Fields of its Purger.
+type Resource interface {
+	InfoProvider
+
+	ID() string
+	Names() []string
+
+	// Size returns the disk space given back by removing the resource, and
+	// the space it shares with other resources
+	Size() (unique, shared int64)
+
+	// Created and Status describe the resource for confirmation
+	Created() time.Time
+	Status() string
+}
+
+// Purger adapts a type of docker resources to Purge
+type Purger interface {
+	// Type is the name of the resource type, e.g. "image"
+	Type() string
+
+	// Fields describes the fields that filters can compare, besides labels
+	Fields() map[string]Field
+
+	// List returns all resources of the type
+	List(ctx context.Context, cli *docker.Client) ([]Resource, error)
+
+	// Remove removes one resource
+	Remove(ctx context.Context, cli *docker.Client, r Resource) error
+}
+
+// preparer is a Purger that needs all listed resources before they are
+// matched, e.g. to add conditions to the matcher
+type preparer interface {
+	Prepare(ctx context.Context, cli *docker.Client, resources []Resource, m *Matcher) error
+}
+
+// keeper is a Purger that keeps some matched resources whatever the filters
+// say. Keep returns why r is kept, or "" if it can be removed.
+type keeper interface {
+	Keep(r Resource) string
+}
+
+// splitter is a Purger whose resources can be removed in parts, like the
+// tags of images
+type splitter interface {
+	// Split returns the parts of r that pass m on their own, and whether they
+	// are all its parts. Resources with one part or less are not split.
+	Split(m *Matcher, r Resource) (parts []string, all bool)
+
+	RemovePart(ctx context.Context, cli *docker.Client, r Resource, part string) error
+}
+
+// pressurer is a Purger that removes resources one by one until enough disk
+// space is free. Pressure ranks candidates in the order they are removed in,
+// and returns nil if there is no disk target.
+type pressurer interface {
+	Pressure(ctx context.Context, cli *docker.Client, candidates []Resource) (*diskPressure, error)
+}
+
+// purgers creates the Purger of each resource type
+var purgers = map[string]func() Purger{
+	"service":   newServicePurger,
+	"container": newContainerPurger,
+	"image":     newImagePurger,
+	"volume":    newVolumePurger,
+	"network":   newNetworkPurger,
+}
+
+// compileFilter compiles f once against the fields of a resource type
+func compileFilter(fields map[string]Field, f Filter) (match func(r InfoProvider) bool, err error) {
+	if strings.HasPrefix(f.Field, labelPrefix) {
+		labels, err := labelMatcher(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(r InfoProvider) bool { return labels(r.Labels()) }, nil
+	}
+	field, ok := fields[f.Field]
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter: %s, field: %s", f.Source, f.Field)
+	}
+	name := f.Field
+	if field.Kind == StringKind {
+		value, err := stringMatcher(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(r InfoProvider) bool {
+			for _, s := range r.StringField(name) {
+				if value(s) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	if field.Kind == BoolKind {
+		want, err := strconv.ParseBool(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch f.Comparator {
+		case EQ:
+		case NE:
+			want = !want
+		default:
+			return nil, fmt.Errorf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
+		}
+		return func(r InfoProvider) bool {
+			v, ok := r.IntField(name)
+			return ok && (v != 0) == want
+		}, nil
+	}
+	op, ok := int64Comparator[f.Comparator]
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter: %s, field: %s", f.Source, f.Comparator)
+	}
+	if field.Kind == TimeKind {
+		if at, ok := parseTimestamp(f.Value); ok {
+			ts := at.Unix()
+			return func(r InfoProvider) bool {
+				v, ok := r.IntField(name)
+				return ok && op(v, ts)
+			}, nil
+		}
+		ago, err := parseDuration(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(r InfoProvider) bool {
+			v, ok := r.IntField(name)
+			return ok && op(ago.Timestamp(), v)
+		}, nil
+	}
+	parse := field.Parse
+	if parse == nil {
+		parse = func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
+	}
+	value, err := parse(f.Value)
+	if err != nil {
+		return nil, err
+	}
+	return func(r InfoProvider) bool {
+		v, ok := r.IntField(name)
+		return ok && op(v, value)
+	}, nil
+}
+
+// Matcher tells which resources pass all filters of a resource type
+type Matcher struct {
+	fields  map[string]Field
+	matches []func(r InfoProvider) bool
+
+	// used are the fields of filters and --where
+	used map[string]bool
+}
+
+// NewMatcher compiles filters against the fields of a resource type
+func NewMatcher(fields map[string]Field, filters ...Filter) (m *Matcher, err error) {
+	m = &Matcher{fields: fields, used: make(map[string]bool)}
+	for _, f := range filters {
+		match, err := m.compile(f)
+		if err != nil {
+			return nil, err
+		}
+		m.matches = append(m.matches, match)
+	}
+	return
+}
+
+// Where adds the expression to the matcher as one more filter
+func (m *Matcher) Where(e Expr) error {
+	p, err := e.Compile(func(f Filter) (Predicate, error) {
+		match, err := m.compile(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(r interface{}) bool { return match(r.(InfoProvider)) }, nil
+	})
+	if err != nil {
+		return err
+	}
+	m.Add(func(r InfoProvider) bool { return p(r) })
+	return nil
+}
+
+// compile compiles f and records its field
+func (m *Matcher) compile(f Filter) (func(r InfoProvider) bool, error) {
+	match, err := compileFilter(m.fields, f)
+	if err == nil {
+		m.used[f.Field] = true
+	}
+	return match, err
+}
+
+// Uses tells if a filter compares any of fields
+func (m *Matcher) Uses(fields ...string) bool {
+	for _, f := range fields {
+		if m.used[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a condition to the matcher as one more filter
+func (m *Matcher) Add(match func(r InfoProvider) bool) {
+	m.matches = append(m.matches, match)
+}
+
+// Empty tells if the matcher has no filter
+func (m *Matcher) Empty() bool {
+	return len(m.matches) == 0
+}
+
+// Satisfied checks if a resource passes all filters. Without filters, no
+// resource passes.
+func (m *Matcher) Satisfied(r InfoProvider) bool {
+	if m.Empty() {
+		return false
+	}
+	for _, match := range m.matches {
+		if !match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Purge lists the resources of p, matches them against filters and
+// --where, asks for confirmation if needed, and removes them in parallel,
+// or one by one under disk pressure. Dry runs only report them.
+// Once ctx is done, no more resource is removed and its error is returned.
+func Purge(ctx context.Context, p Purger, filters ...Filter) (summary Summary, err error) {
+	summary.Resource = p.Type()
+	m, err := NewMatcher(p.Fields(), filters...)
+	if err != nil {
+		return
+	}
+	if whereExpr != nil {
+		if err = m.Where(whereExpr); err != nil {
+			return
+		}
+	}
+	cli, err := dockerClient(ctx)
+	if err != nil {
+		return
+	}
+	resources, err := p.List(ctx, cli)
+	if err != nil {
+		return
+	}
+	if prep, ok := p.(preparer); ok {
+		if err = prep.Prepare(ctx, cli, resources, m); err != nil {
+			return
+		}
+	}
+	var candidates []Resource
+	for _, r := range resources {
+		if !m.Satisfied(r) {
+			summary.Skipped++
+			continue
+		}
+		if k, ok := p.(keeper); ok {
+			if reason := k.Keep(r); reason != "" {
+				unique, _ := r.Size()
+				reporter.Record(newSkipRecord(summary.Resource, r.ID(), r.Names(), unique, filters, reason))
+				summary.Skipped++
+				continue
+			}
+		}
+		candidates = append(candidates, r)
+	}
+	var pressure *diskPressure
+	if pr, ok := p.(pressurer); ok {
+		if pressure, err = pr.Pressure(ctx, cli, candidates); err != nil {
+			return
+		}
+	}
+
+	// under pressure, only the candidates expected to relieve it are
+	// confirmed, none if there is no pressure
+	planned := candidates
+	if pressure != nil {
+		planned = candidates[:pressure.planned(candidates)]
+	}
+	shown := make([]Candidate, len(planned))
+	for i, r := range planned {
+		unique, _ := r.Size()
+		shown[i] = Candidate{r.ID(), r.Names(), unique, r.Created(), r.Status()}
+	}
+	asked := needConfirm(len(shown))
+	selected, err := confirmCandidates(ctx, &summary, shown, filters)
+	if err != nil {
+		return
+	}
+	var confirmed []Resource
+	for i, r := range planned {
+		if selected[i] {
+			confirmed = append(confirmed, r)
+		}
+	}
+	// the candidates past the plan are only removed without confirmation,
+	// as long as the pressure lasts
+	for _, r := range candidates[len(planned):] {
+		if !asked {
+			confirmed = append(confirmed, r)
+			continue
+		}
+		unique, _ := r.Size()
+		reporter.Record(newSkipRecord(summary.Resource, r.ID(), r.Names(), unique, filters, "reached "+pressure.target))
+		summary.Skipped++
+	}
+	candidates = confirmed
+
+	if pressure == nil {
+		removals := make([]removal, len(candidates))
+		runParallel(ctx, len(candidates), func(i int) {
+			removals[i] = removeResource(ctx, cli, p, m, candidates[i], filters)
+		})
+		reportRemovals(removals, &summary)
+		return summary, ctx.Err()
+	}
+	for _, r := range candidates {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		unique, _ := r.Size()
+		if pressure.relieved() {
+			reporter.Record(newSkipRecord(summary.Resource, r.ID(), r.Names(), unique, filters, "reached "+pressure.target))
+			summary.Skipped++
+			continue
+		}
+		rm := removeResource(ctx, cli, p, m, r, filters)
+		reportRemovals([]removal{rm}, &summary)
+		if rm.summary.Removed > 0 {
+			if err = pressure.freed(unique); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// removeResource removes one matched resource. If p splits resources, only
+// the matching parts are removed, and the resource itself goes with the
+// last of them. A resource that only matches with all its parts together
+// is removed as a whole.
+func removeResource(ctx context.Context, cli *docker.Client, p Purger, m *Matcher, r Resource, filters []Filter) (rm removal) {
+	if s, ok := p.(splitter); ok {
+		if parts, all := s.Split(m, r); len(parts) > 1 || len(parts) == 1 && !all {
+			if !all {
+				removeParts(ctx, cli, s, p.Type(), r, parts, filters, &rm)
+				return
+			}
+			if !removeParts(ctx, cli, s, p.Type(), r, parts[:len(parts)-1], filters, &rm) {
+				return
+			}
+		}
+	}
+	unique, shared := r.Size()
+	var err error
+	if !dryRun {
+		err = p.Remove(ctx, cli, r)
+	}
+	rm.records = append(rm.records, newRecord(p.Type(), r.ID(), r.Names(), unique, filters, err))
+	if err != nil {
+		rm.summary.Failed++
+		return
+	}
+	rm.summary.Removed++
+	rm.summary.Reclaimed += unique
+	rm.summary.Shared += shared
+	return
+}
+
+// removeParts removes parts of a resource one at a time, e.g. untags an
+// image. It returns false if any part is not removed.
+func removeParts(ctx context.Context, cli *docker.Client, s splitter, resource string, r Resource, parts []string, filters []Filter, rm *removal) (ok bool) {
+	ok = true
+	for _, part := range parts {
+		var err error
+		if !dryRun {
+			err = s.RemovePart(ctx, cli, r, part)
+		}
+		rm.records = append(rm.records, newUntagRecord(resource, r.ID(), part, filters, err))
+		if err != nil {
+			rm.summary.Failed++
+			ok = false
+		} else {
+			rm.summary.Untagged++
+		}
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestMatcherEmpty(t *testing.T) {
+	m, err := NewMatcher(imageFields)
+	if err != nil {
+		t.Fatalf("error when creating matcher: %s", err)
+	}
+	if m.Satisfied(&imageResource{}) {
+		t.Error("no resource should pass a matcher without filters")
+	}
+	m.Add(func(r InfoProvider) bool { return true })
+	if !m.Satisfied(&imageResource{}) {
+		t.Error("should pass the added condition")
+	}
+}
+
+func TestCompileFilterError(t *testing.T) {
+	for _, f := range []Filter{
+		{"unknown=1", "unknown", EQ, "1"},
+		{"dangling>true", "dangling", GT, "true"},
+		{"created*=3d", "created", GLOB, "3d"},
+		{"size>big", "size", GT, "big"},
+	} {
+		if _, err := compileFilter(testFields, f); err == nil {
+			t.Errorf("should fail to compile: %s", f.Source)
+		}
+	}
+}
+
+// testFields are fields of every kind, see testResource
+var testFields = map[string]Field{
+	"created":  {Kind: TimeKind},
+	"size":     {Kind: IntKind, Parse: parseSize},
+	"dangling": {Kind: BoolKind},
+	"name":     {Kind: StringKind},
+}
+
+func TestRemoveResource(t *testing.T) {
+	defer func(d bool) { dryRun = d }(dryRun)
+	dryRun = false
+	m, _ := NewMatcher(testFields, Filter{"name=old", "name", EQ, "old"})
+
+	// only the matching parts are removed
+	p := &testPurger{}
+	rm := removeResource(context.Background(), nil, p, m, &testResource{names: []string{"old", "new"}}, nil)
+	if len(p.removed) != 1 || p.removed[0] != "part:old" || rm.summary.Untagged != 1 || rm.summary.Removed != 0 {
+		t.Errorf("wrong removal of a partly matching resource: %v, %+v", p.removed, rm.summary)
+	}
+
+	// the resource goes with its last part
+	p = &testPurger{}
+	rm = removeResource(context.Background(), nil, p, m, &testResource{names: []string{"old", "old"}, size: 10}, nil)
+	if len(p.removed) != 2 || p.removed[1] != "res" || rm.summary.Removed != 1 || rm.summary.Reclaimed != 10 {
+		t.Errorf("wrong removal of a matching resource: %v, %+v", p.removed, rm.summary)
+	}
+
+	// the resource is kept if a part fails
+	p = &testPurger{fail: true}
+	rm = removeResource(context.Background(), nil, p, m, &testResource{names: []string{"old", "old"}}, nil)
+	if len(p.removed) != 0 || rm.summary.Failed != 1 || len(rm.records) != 1 {
+		t.Errorf("wrong removal after a failed part: %v, %+v", p.removed, rm.summary)
+	}
+}
+
+// testResource is a Resource whose names are its parts
+type testResource struct {
+	names []string
+	size  int64
+}
+
+func (r *testResource) StringField(f string) []string {
+	if f == "name" {
+		return r.names
+	}
+	return nil
+}
+
+func (r *testResource) IntField(f string) (int64, bool) { return 0, false }
+func (r *testResource) Labels() map[string]string       { return nil }
+func (r *testResource) ID() string                      { return "id" }
+func (r *testResource) Names() []string                 { return r.names }
+func (r *testResource) Size() (unique, shared int64)    { return r.size, 0 }
+func (r *testResource) Created() time.Time              { return time.Time{} }
+func (r *testResource) Status() string                  { return "" }
+
+// testPurger is a splitter recording what it removes
+type testPurger struct {
+	removed []string
+	fail    bool
+}
+
+func (p *testPurger) Type() string             { return "test" }
+func (p *testPurger) Fields() map[string]Field { return testFields }
+func (p *testPurger) List(ctx context.Context, cli *docker.Client) ([]Resource, error) {
+	return nil, nil
+}
+
+func (p *testPurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	p.removed = append(p.removed, "res")
+	return nil
+}
+
+func (p *testPurger) Split(m *Matcher, r Resource) (parts []string, all bool) {
+	names := r.Names()
+	for _, name := range names {
+		if m.Satisfied(&testResource{names: []string{name}}) {
+			parts = append(parts, name)
+		}
+	}
+	return parts, len(parts) == len(names)
+}
+
+func (p *testPurger) RemovePart(ctx context.Context, cli *docker.Client, r Resource, part string) error {
+	if p.fail {
+		return errors.New("conflict")
+	}
+	p.removed = append(p.removed, "part:"+part)
+	return nil
+}
+
+// pressurePurger lists resources and removes them under pressure
+type pressurePurger struct {
+	testPurger
+	resources []Resource
+	pressure  *diskPressure
+}
+
+func (p *pressurePurger) List(ctx context.Context, cli *docker.Client) ([]Resource, error) {
+	return p.resources, nil
+}
+
+func (p *pressurePurger) Pressure(ctx context.Context, cli *docker.Client, candidates []Resource) (*diskPressure, error) {
+	return p.pressure, nil
+}
+
+func TestPurgeUnderPressureConfirm(t *testing.T) {
+	defer func(c *docker.Client, d bool, r Reporter, over int, in io.Reader, term func() bool) {
+		client, dryRun, reporter, confirmOver, confirmIn, confirmTerminal = c, d, r, over, in, term
+	}(client, dryRun, reporter, confirmOver, confirmIn, confirmTerminal)
+	client, _ = docker.NewClient("unix:///nonexistent.sock")
+	dryRun, reporter, confirmOver = false, &recordBuffer{}, 50
+	confirmTerminal = func() bool { return true }
+
+	var resources []Resource
+	for i := 0; i < 60; i++ {
+		resources = append(resources, &testResource{names: []string{"old"}, size: 1})
+	}
+	filter := Filter{"name=old", "name", EQ, "old"}
+
+	// the 6 resources expected to relieve the pressure do not need a
+	// confirmation, nobody is asked
+	confirmIn = strings.NewReader("")
+	p := &pressurePurger{resources: resources, pressure: &diskPressure{goal: 10, free: 4, target: "10B free"}}
+	summary, err := Purge(context.Background(), p, filter)
+	if err != nil || summary.Removed != 6 || summary.Skipped != 54 {
+		t.Errorf("wrong purge under pressure: %+v, %v", summary, err)
+	}
+
+	// without pressure, nothing is removed nor asked
+	p = &pressurePurger{resources: resources, pressure: &diskPressure{goal: 0, free: 4, target: "10B free"}}
+	if summary, err = Purge(context.Background(), p, filter); err != nil || summary.Removed != 0 || summary.Skipped != 60 {
+		t.Errorf("wrong purge without pressure: %+v, %v", summary, err)
+	}
+
+	// past confirmOver, only the planned resources are asked for
+	confirmOver = 3
+	out := &bytes.Buffer{}
+	defer func(w io.Writer) { confirmOut = w }(confirmOut)
+	confirmIn, confirmOut = strings.NewReader("a\n"), out
+	p = &pressurePurger{resources: resources, pressure: &diskPressure{goal: 10, free: 4, target: "10B free"}}
+	if summary, err = Purge(context.Background(), p, filter); err != nil || summary.Removed != 6 || summary.Skipped != 54 {
+		t.Errorf("wrong confirmed purge under pressure: %+v, %v", summary, err)
+	}
+	if !strings.Contains(out.String(), "Remove 6 tests?") {
+		t.Errorf("wrong question: %s", out)
+	}
+}
@@ -0,0 +1,70 @@
+package purge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// keepLast is the number of the most recent images kept per group,
+	// 0 disables retention
+	keepLast int
+
+	// groupBy decides how images are grouped for keepLast
+	groupBy string
+)
+
+// imageGroups groups images by repository, parsed from RepoTags. An image
+// tagged in several repositories is in several groups, and untagged images
+// are in none.
+func imageGroups(images []docker.APIImages) map[string][]docker.APIImages {
+	groups := make(map[string][]docker.APIImages)
+	for _, img := range images {
+		seen := make(map[string]bool)
+		for _, ref := range img.RepoTags {
+			if strings.HasPrefix(ref, "<none>") {
+				continue
+			}
+			repo := imageRepo(ref)
+			if seen[repo] {
+				continue
+			}
+			seen[repo] = true
+			groups[repo] = append(groups[repo], img)
+		}
+	}
+	return groups
+}
+
+// RetentionCandidates returns the IDs of images that are beyond the keep
+// most recent ones of every group they are in
+func RetentionCandidates(images []docker.APIImages, keep int, group string) (candidates map[string]bool, err error) {
+	if group != "repo" {
+		return nil, fmt.Errorf("unsupported group: %s", group)
+	}
+	kept := make(map[string]bool)
+	candidates = make(map[string]bool)
+	for _, imgs := range imageGroups(images) {
+		sort.Slice(imgs, func(i, j int) bool {
+			if imgs[i].Created != imgs[j].Created {
+				return imgs[i].Created > imgs[j].Created
+			}
+			return imgs[i].ID < imgs[j].ID
+		})
+		for i, img := range imgs {
+			if i < keep {
+				kept[img.ID] = true
+			} else {
+				candidates[img.ID] = true
+			}
+		}
+	}
+	// an image kept in any group is kept
+	for id := range kept {
+		delete(candidates, id)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package purge
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestRetentionCandidates(t *testing.T) {
+	images := []docker.APIImages{
+		{ID: "app1", RepoTags: []string{"app:v1"}, Created: 1},
+		{ID: "app2", RepoTags: []string{"app:v2"}, Created: 2},
+		{ID: "app3", RepoTags: []string{"app:v3", "app:latest"}, Created: 3},
+		{ID: "web1", RepoTags: []string{"web:v1"}, Created: 1},
+		// kept as the newest of registry:5000/web, though old in app
+		{ID: "both", RepoTags: []string{"app:v0", "registry:5000/web:v0"}, Created: 0},
+		{ID: "none", RepoTags: []string{"<none>:<none>"}, Created: 0},
+	}
+	candidates, err := RetentionCandidates(images, 2, "repo")
+	if err != nil {
+		t.Fatalf("retention error: %s", err)
+	}
+	expected := map[string]bool{"app1": true}
+	if len(candidates) != len(expected) {
+		t.Errorf("wrong candidates: %v, expected: %v", candidates, expected)
+	}
+	for id := range expected {
+		if !candidates[id] {
+			t.Errorf("%s should be a candidate, candidates: %v", id, candidates)
+		}
+	}
+	if _, err = RetentionCandidates(images, 2, "label"); err == nil {
+		t.Error("unsupported group should be rejected")
+	}
+}
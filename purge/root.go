@@ -1,12 +1,17 @@
 package purge
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/fsouza/go-dockerclient"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -15,25 +20,55 @@ var (
 )
 
 var (
+	// dockerUri is the docker host being purged, the docker of the
+	// environment if empty
 	dockerUri string
 
+	// client is the docker client shared by all purges of the process
+	client *docker.Client
+
 	// filter stores filter strings from CMD
 	filter []string
 
 	// dryRun with it set to true, only print operations without actually applying them
 	dryRun bool
 
-	// durationPtn is responsible for matching duration string from CMD
-	durationPtn = regexp.MustCompile(`((?P<years>\d+?)y)?((?P<months>\d+?)m)?((?P<days>\d+?)d)?`)
+	// force removes resources that the daemon would refuse to remove
+	force bool
+
+	// noPrune keeps the untagged parents of removed images
+	noPrune bool
+
+	// output is the output format, one of "table", "json" and "yaml"
+	output string
+
+	// where is a boolean expression of filters from CMD, ANDed with -f filters
+	where string
+
+	// whereExpr is parsed from where, nil if where is not given
+	whereExpr Expr
+
+	// durationPtn matches one part of a duration string from CMD, a duration
+	// is a sequence of them, e.g. "1y6m", "2w3d" or "6h30min"
+	durationPtn = regexp.MustCompile(`(\d+)(y|min|m|M|w|d|h|s)`)
+
+	// isoDurationPtn matches ISO-8601 durations, the unit of each group is
+	// its name in durationPtn
+	isoDurationPtn = regexp.MustCompile(`^P(?:(?P<y>\d+)Y)?(?:(?P<m>\d+)M)?(?:(?P<w>\d+)W)?(?:(?P<d>\d+)D)?` +
+		`(?:T(?:(?P<h>\d+)H)?(?:(?P<min>\d+)M)?(?:(?P<s>\d+)S)?)?$`)
 
-	// statusPtn is responsible for matching docker resource, mainly container status
-	statusPtn = regexp.MustCompile(`(?P<status>\w+) ?(\((?P<code>\d+)\))? ?(?P<num>\d+)? ?(?P<unit>\w+)? ?(ago)?`)
+	// timestampLayouts are the layouts of absolute times in time filters
+	timestampLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
 
 	// sizePtn matches human readable size. "500m", "2G", etc
 	sizePtn = regexp.MustCompile(`(?P<amount>\d+)(?P<unit>[k|m|g|K|M|G])`)
 
-	// filterPtn matches a whole filter string
-	filterPtn = regexp.MustCompile(`(?P<field>\w+)(?P<op>=|!=|>|>=|<|<=)(?P<value>[^(=|\s)]+)`)
+	// labelPtn matches a label filter without value, "label.keep" or "!label.keep"
+	labelPtn = regexp.MustCompile(`^(!?)(label\.[\w.\-]+)$`)
+
+	// filterPtn matches a whole filter string. field may be dotted, e.g. "label.team".
+	// longer operators go first so that ">=" is not taken as ">"
+	filterPtn = regexp.MustCompile(`^(?P<field>[\w.\-]+)(?P<op>!=|>=|<=|~=|\*=|\^=|=|>|<)(?P<value>.+)$`)
 )
 
 // rootCmd the entry of dkp
@@ -42,59 +77,160 @@ var rootCmd = &cobra.Command{
 	Short: "purge resources",
 	Long: "purge allows you to clean images, containers, swarm services with filters",
 	// Run: Purge,
+	PersistentPreRunE: setup,
+	// errors go to stderr in Execute, stdout is left to the reporter
+	SilenceErrors: true,
 }
 
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 }
 
 
+// setup selects the reporter with --output, checks --parallel and --rate,
+// parses --where and cancels the context of the command on SIGTERM or
+// interrupt. The daemon handles signals on its own.
+func setup(cmd *cobra.Command, args []string) (err error) {
+	// the command line is valid, usage would only clutter the output
+	cmd.SilenceUsage = true
+	reporter, err = NewReporter(output, os.Stdout)
+	if err != nil {
+		return
+	}
+	if err = checkPool(); err != nil {
+		return
+	}
+	if err = setupEndpoint(); err != nil {
+		return
+	}
+	if where != "" {
+		whereExpr, err = ParseExpr(where)
+		if err != nil {
+			return fmt.Errorf("invalid --where: %s", err)
+		}
+	}
+	// images are only listed after connecting to docker, fail before
+	if _, err = ParseDiskTarget(untilFree, highWatermark, lowWatermark); err != nil {
+		return
+	}
+	if cmd != cmdDaemon {
+		ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, os.Interrupt)
+		// a second signal kills the process as usual
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+		cmd.SetContext(ctx)
+	}
+	return
+}
+
+
 func Version(cmd *cobra.Command, args []string) {
 
 }
 
-// Ago stores duration from now
+// Ago stores duration from now. Calendar units go first, then Duration.
 type Ago struct {
 	Years, Months, Days int
+
+	// Duration is the part counted in hours, minutes and seconds
+	Duration time.Duration
+}
+
+// Time returns the time that is a ago from now
+func (a *Ago) Time(now time.Time) time.Time {
+	return now.AddDate(-a.Years, -a.Months, -a.Days).Add(-a.Duration)
 }
 
 // Timestamp convert ago to a specific timestamp
 func (a *Ago) Timestamp() int64 {
-	return time.Now().AddDate(-a.Years, -a.Months, -a.Days).Unix()
+	return a.Time(time.Now()).Unix()
 }
 
-
-// parseDuration parses strings that are like "10m", "1d", "5y"
-// see durationPtn for pattern
+// parseDuration parses strings that are like "10d", "1y6m", "6h30min" or
+// the ISO-8601 "P1M2DT3H", see durationPtn and isoDurationPtn for patterns.
+// "m" is months, "min" and "M" are minutes.
 func parseDuration(d string) (a *Ago, err error) {
 	a = new(Ago)
-	m := durationPtn.FindStringSubmatch(d)
-	if m != nil {
-		return a, Mismatched
+	if strings.HasPrefix(d, "P") {
+		return parseISODuration(d)
 	}
-	for i, name := range durationPtn.SubexpNames() {
-		if i != 0 && name != "" && m[i] != "" {
-			switch name {
-			case "years":
-				a.Years, err = strconv.Atoi(m[i])
-			case "months":
-				a.Months, err = strconv.Atoi(m[i])
-			case "days":
-				a.Days, err = strconv.Atoi(m[i])
-			}
-			if err != nil {
-				return
-			}
+	matches := durationPtn.FindAllStringSubmatchIndex(d, -1)
+	end := 0
+	for _, m := range matches {
+		if m[0] != end {
+			break
 		}
+		end = m[1]
 	}
+	if len(matches) == 0 || end != len(d) {
+		return a, fmt.Errorf("invalid duration: %s", d)
+	}
+	for _, m := range matches {
+		n, err := strconv.Atoi(d[m[2]:m[3]])
+		if err != nil {
+			return a, err
+		}
+		a.add(n, d[m[4]:m[5]])
+	}
+	return a, nil
+}
 
+// parseISODuration parses ISO-8601 durations like "P1Y2M", "P2W" or "PT36H"
+func parseISODuration(d string) (a *Ago, err error) {
+	a = new(Ago)
+	m := isoDurationPtn.FindStringSubmatch(d)
+	if m == nil || d == "P" || strings.HasSuffix(d, "T") {
+		return a, fmt.Errorf("invalid ISO-8601 duration: %s", d)
+	}
+	for i, unit := range isoDurationPtn.SubexpNames() {
+		if i == 0 || m[i] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i])
+		if err != nil {
+			return a, err
+		}
+		a.add(n, unit)
+	}
 	return a, nil
 }
 
+// add adds n of a unit of durationPtn to a
+func (a *Ago) add(n int, unit string) {
+	switch unit {
+	case "y":
+		a.Years += n
+	case "m":
+		a.Months += n
+	case "w":
+		a.Days += 7 * n
+	case "d":
+		a.Days += n
+	case "h":
+		a.Duration += time.Duration(n) * time.Hour
+	case "min", "M":
+		a.Duration += time.Duration(n) * time.Minute
+	case "s":
+		a.Duration += time.Duration(n) * time.Second
+	}
+}
+
+// parseTimestamp parses absolute times like "2024-01-01", "2024-01-01 15:04"
+// or RFC 3339. Times without zone are local.
+func parseTimestamp(s string) (t time.Time, ok bool) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return
+}
 
 // parseSize parses strings that are formed of "12m", "2G", etc
 // see sizePtn for the pattern
@@ -126,18 +262,104 @@ func init() {
 	rootCmd.AddCommand(cmdImg)
 	rootCmd.AddCommand(cmdCtn)
 	rootCmd.AddCommand(cmdSvc)
+	rootCmd.AddCommand(cmdVol)
+	rootCmd.AddCommand(cmdNet)
+	rootCmd.AddCommand(cmdAll)
+	rootCmd.AddCommand(cmdApply)
+	rootCmd.AddCommand(cmdDaemon)
 	rootCmd.PersistentFlags().StringSliceVarP(
 		&filter, "filter", "f", nil, "filter conditions")
-	rootCmd.PersistentFlags().StringVarP(
-		&dockerUri,
+	rootCmd.PersistentFlags().StringSliceVarP(
+		&dockerUris,
 		"docker",
 		"d",
-		"",
-		"docker uri. if not provided, use the default")
+		nil,
+		"docker uri, repeat it to purge several hosts. if not provided, use the default")
+	rootCmd.PersistentFlags().StringVar(
+		&inventory, "inventory", "", "file of docker uris to purge, one per line")
+	rootCmd.PersistentFlags().BoolVar(
+		&tlsVerify, "tlsverify", false, "use TLS and verify the docker daemon")
+	rootCmd.PersistentFlags().StringVar(
+		&tlsCACert, "tlscacert", "", "trust certs signed only by this CA, defaults to ca.pem of DOCKER_CERT_PATH or ~/.docker")
+	rootCmd.PersistentFlags().StringVar(
+		&tlsCert, "tlscert", "", "path to TLS certificate file, defaults to cert.pem of DOCKER_CERT_PATH or ~/.docker")
+	rootCmd.PersistentFlags().StringVar(
+		&tlsKey, "tlskey", "", "path to TLS key file, defaults to key.pem of DOCKER_CERT_PATH or ~/.docker")
+	rootCmd.PersistentFlags().StringVar(
+		&apiVersion, "api-version", "", "docker API version, negotiated with the daemon by default")
+	rootCmd.PersistentFlags().DurationVar(
+		&requestTimeout, "timeout", 2*time.Minute, "timeout of each docker request, 0 for none")
+	rootCmd.PersistentFlags().StringVar(
+		&contextName, "context", "", "name of a docker CLI context to connect to, see docker context ls")
 	rootCmd.PersistentFlags().BoolVarP(
 		&dryRun,
 		"dry-run",
 		"p",
 		false,
 		"Only prints actions but not actually apply them")
+	rootCmd.PersistentFlags().StringVarP(
+		&output,
+		"output",
+		"o",
+		"table",
+		"output format: table, json or yaml")
+	rootCmd.PersistentFlags().StringVarP(
+		&where,
+		"where",
+		"w",
+		"",
+		`filter expression with and, or, not and grouping. e.g. '(tag="<none>" or created>3m) and not name=nginx'`)
+	rootCmd.PersistentFlags().BoolVarP(
+		&interactive, "interactive", "i", false, "show matched resources and ask which ones to remove")
+	rootCmd.PersistentFlags().IntVar(
+		&confirmOver, "confirm-over", 50, "ask for confirmation on a terminal when more resources match, 0 never asks")
+	rootCmd.PersistentFlags().BoolVarP(
+		&assumeYes, "yes", "y", false, "remove matched resources without asking")
+	rootCmd.PersistentFlags().IntVar(
+		&parallel, "parallel", 1, "number of resources of a type removed at the same time")
+	rootCmd.PersistentFlags().Float64Var(
+		&rate, "rate", 0, "maximum number of resources removed per second, 0 is unlimited")
+	cmdImg.Flags().StringSliceVar(
+		&keepImages, "keep", nil, "name patterns of images that are never removed, e.g. 'library/*'")
+	cmdImg.Flags().IntVar(
+		&keepLast, "keep-last", 0, "only remove images beyond the N most recent ones of each group")
+	cmdImg.Flags().StringVar(
+		&groupBy, "group-by", "repo", "how images are grouped for --keep-last, only repo is supported")
+	cmdCtn.Flags().BoolVar(
+		&removeVolumes, "volumes", false, "remove the anonymous volumes of removed containers")
+	cmdCtn.Flags().BoolVar(
+		&force, "force", false, "force the removal of containers, even running ones")
+	cmdCtn.Flags().BoolVar(
+		&stopRunning, "stop", false, "stop running containers before removing them")
+	cmdCtn.Flags().DurationVar(
+		&stopTimeout, "stop-timeout", 10*time.Second, "time to wait for containers to stop before killing them")
+	cmdImg.Flags().BoolVar(
+		&force, "force", false, "force the removal of images, e.g. with dependent child images")
+	cmdImg.Flags().BoolVar(
+		&noPrune, "no-prune", false, "do not remove the untagged parents of removed images")
+	cmdImg.Flags().StringVar(
+		&untilFree, "until-free", "", "only remove images, oldest and largest first, until the docker data root has this free space, e.g. 20G. local docker socket only")
+	cmdImg.Flags().StringVar(
+		&highWatermark, "high-watermark", "", "only remove images when the usage of the docker data root is above this, e.g. 85%. local docker socket only")
+	cmdImg.Flags().StringVar(
+		&lowWatermark, "low-watermark", "", "usage of the docker data root to reach after --high-watermark, defaults to it, e.g. 70%")
+	cmdAll.Flags().StringSliceVar(
+		&keepImages, "keep", nil, "name patterns of images that are never removed, e.g. 'library/*'")
+	cmdApply.Flags().StringVarP(&policyFile, "config", "c", "", "path of the policy file")
+	cmdApply.MarkFlagRequired("config")
+	cmdAll.Flags().StringSliceVar(&allSvcFilter, "service", nil, "filter conditions of services")
+	cmdAll.Flags().StringSliceVar(&allCtnFilter, "container", nil, "filter conditions of containers")
+	cmdAll.Flags().StringSliceVar(&allImgFilter, "image", nil, "filter conditions of images")
+	cmdAll.Flags().StringSliceVar(&allVolFilter, "volume", nil, "filter conditions of volumes")
+	cmdAll.Flags().StringSliceVar(&allNetFilter, "network", nil, "filter conditions of networks")
+	cmdDaemon.Flags().DurationVar(&interval, "interval", 0, "time between runs, e.g. 1h")
+	cmdDaemon.Flags().StringVar(&cronSpec, "cron", "", "cron expression of runs, e.g. '0 3 * * *'")
+	cmdDaemon.Flags().StringVarP(&policyFile, "config", "c", "", "path of the policy file run on every run")
+	cmdDaemon.Flags().StringSliceVar(
+		&keepImages, "keep", nil, "name patterns of images that are never removed, e.g. 'library/*'")
+	cmdDaemon.Flags().StringSliceVar(&allSvcFilter, "service", nil, "filter conditions of services")
+	cmdDaemon.Flags().StringSliceVar(&allCtnFilter, "container", nil, "filter conditions of containers")
+	cmdDaemon.Flags().StringSliceVar(&allImgFilter, "image", nil, "filter conditions of images")
+	cmdDaemon.Flags().StringSliceVar(&allVolFilter, "volume", nil, "filter conditions of volumes")
+	cmdDaemon.Flags().StringSliceVar(&allNetFilter, "network", nil, "filter conditions of networks")
 }
@@ -1,21 +1,149 @@
 package purge
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/fsouza/go-dockerclient"
 	"github.com/spf13/cobra"
 )
 
 var cmdSvc = &cobra.Command{
-	Use: "service",
-	Short: "Purge stopped services",
-	Long: "Purge stopped services",
-	RunE: RunCmdService,
+	Use:   "service",
+	Short: "Purge swarm services",
+	Long:  "Purge swarm services",
+	RunE:  RunCmdService,
 }
 
-func RunCmdService(cmd *cobra.Command, args []string) error {
-	return RemoveServices()
+// serviceFields are the fields of services that filters can compare
+var serviceFields = map[string]Field{
+	"created":  {Kind: TimeKind},
+	"updated":  {Kind: TimeKind},
+	"name":     {Kind: StringKind},
+	"replicas": {Kind: IntKind},
+	"idle":     {Kind: TimeKind},
 }
 
+// serviceResource adapts a service and its tasks to Resource
+type serviceResource struct {
+	swarm.Service
+	tasks []swarm.Task
+}
 
-func RemoveServices(filters ...Filter) error {
+func (s *serviceResource) StringField(f string) []string {
+	if f == "name" {
+		return []string{s.Spec.Name}
+	}
 	return nil
 }
+
+// IntField returns the desired replica count only for replicated services,
+// and the idle time only for services which have no running task
+func (s *serviceResource) IntField(f string) (int64, bool) {
+	switch f {
+	case "created":
+		return s.CreatedAt.Unix(), true
+	case "updated":
+		return s.UpdatedAt.Unix(), true
+	case "replicas":
+		mode := s.Spec.Mode.Replicated
+		if mode == nil {
+			return 0, false
+		}
+		if mode.Replicas == nil {
+			return 0, true
+		}
+		return int64(*mode.Replicas), true
+	case "idle":
+		// a service without any task has been idle since it was updated,
+		// otherwise since its last task changed its state
+		last := s.UpdatedAt
+		for _, task := range s.tasks {
+			if task.Status.State == swarm.TaskStateRunning {
+				return 0, false
+			}
+			if task.Status.Timestamp.After(last) {
+				last = task.Status.Timestamp
+			}
+		}
+		return last.Unix(), true
+	}
+	return 0, false
+}
+
+func (s *serviceResource) Labels() map[string]string    { return s.Spec.Labels }
+func (s *serviceResource) ID() string                   { return s.Service.ID }
+func (s *serviceResource) Names() []string              { return []string{s.Spec.Name} }
+func (s *serviceResource) Size() (unique, shared int64) { return 0, 0 }
+func (s *serviceResource) Created() time.Time           { return s.CreatedAt }
+func (s *serviceResource) Status() string               { return serviceStatus(s.Service, s.tasks) }
+
+// servicePurger purges swarm services
+type servicePurger struct{}
+
+func newServicePurger() Purger {
+	return servicePurger{}
+}
+
+func (servicePurger) Type() string             { return "service" }
+func (servicePurger) Fields() map[string]Field { return serviceFields }
+
+// List returns services with their tasks, which the "idle" filter needs
+func (servicePurger) List(ctx context.Context, cli *docker.Client) (resources []Resource, err error) {
+	services, err := cli.ListServices(docker.ListServicesOptions{Context: ctx})
+	if err != nil {
+		return
+	}
+	tasks, err := cli.ListTasks(docker.ListTasksOptions{Context: ctx})
+	if err != nil {
+		return
+	}
+	byService := make(map[string][]swarm.Task)
+	for _, task := range tasks {
+		byService[task.ServiceID] = append(byService[task.ServiceID], task)
+	}
+	for _, svc := range services {
+		resources = append(resources, &serviceResource{svc, byService[svc.ID]})
+	}
+	return
+}
+
+func (servicePurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	return cli.RemoveService(docker.RemoveServiceOptions{ID: r.ID(), Context: ctx})
+}
+
+func RunCmdService(cmd *cobra.Command, args []string) error {
+	var filters []Filter
+	for _, f := range filter {
+		reporter.Filter(f)
+		parsed, err := parseFilter(f)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, parsed)
+	}
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return single(RemoveServices(ctx, filters...))
+	})
+}
+
+// serviceStatus describes the replicas of a service, for confirmation
+func serviceStatus(svc swarm.Service, tasks []swarm.Task) string {
+	running := 0
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning {
+			running++
+		}
+	}
+	if svc.Spec.Mode.Replicated != nil && svc.Spec.Mode.Replicated.Replicas != nil {
+		return fmt.Sprintf("%d/%d running", running, *svc.Spec.Mode.Replicated.Replicas)
+	}
+	return fmt.Sprintf("%d running", running)
+}
+
+// RemoveServices purges services with filters, see servicePurger
+func RemoveServices(ctx context.Context, filters ...Filter) (Summary, error) {
+	return Purge(ctx, newServicePurger(), filters...)
+}
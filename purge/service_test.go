@@ -0,0 +1,96 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestServiceCreatedFilter(t *testing.T) {
+	f := Filter{"created>10d", "created", GT, "10d"}
+	m, err := NewMatcher(serviceFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	svc := swarm.Service{}
+	svc.CreatedAt = yesterday
+	if m.Satisfied(&serviceResource{Service: svc}) {
+		t.Errorf("filter the wrong result. created 1d ago")
+	}
+	svc.CreatedAt = yesterday.AddDate(0, -1, 0)
+	if !m.Satisfied(&serviceResource{Service: svc}) {
+		t.Errorf("wrong filter result: created: 1m1d ago")
+	}
+}
+
+func TestServiceReplicasFilter(t *testing.T) {
+	f := Filter{"replicas=0", "replicas", EQ, "0"}
+	m, err := NewMatcher(serviceFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	var zero, two uint64 = 0, 2
+	svc := swarm.Service{}
+	svc.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &zero}
+	if !m.Satisfied(&serviceResource{Service: svc}) {
+		t.Error("should pass filter. replicas: 0")
+	}
+	svc.Spec.Mode.Replicated.Replicas = &two
+	if m.Satisfied(&serviceResource{Service: svc}) {
+		t.Error("should not pass filter. replicas: 2")
+	}
+	svc.Spec.Mode = swarm.ServiceMode{Global: &swarm.GlobalService{}}
+	if m.Satisfied(&serviceResource{Service: svc}) {
+		t.Error("should not pass filter. global service")
+	}
+}
+
+func TestServiceLabelFilter(t *testing.T) {
+	f, err := parseFilter("label.team=payments")
+	if err != nil {
+		t.Fatalf("parse filter error: %s", err)
+	}
+	m, err := NewMatcher(serviceFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	svc := swarm.Service{}
+	svc.Spec.Labels = map[string]string{"team": "payments"}
+	if !m.Satisfied(&serviceResource{Service: svc}) {
+		t.Errorf("should pass filter. filter: %s, actual: %v", f.Source, svc.Spec.Labels)
+	}
+	svc.Spec.Labels = map[string]string{"team": "search"}
+	if m.Satisfied(&serviceResource{Service: svc}) {
+		t.Errorf("should not pass filter. filter: %s, actual: %v", f.Source, svc.Spec.Labels)
+	}
+}
+
+func TestServiceIdleFilter(t *testing.T) {
+	f := Filter{"idle>3d", "idle", GT, "3d"}
+	old := time.Now().AddDate(0, 0, -10)
+	m, err := NewMatcher(serviceFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	idle := &serviceResource{tasks: []swarm.Task{
+		{Status: swarm.TaskStatus{State: swarm.TaskStateShutdown, Timestamp: old}},
+	}}
+	if !m.Satisfied(idle) {
+		t.Error("should pass filter. no task running for 10 days")
+	}
+	running := &serviceResource{tasks: []swarm.Task{
+		{Status: swarm.TaskStatus{State: swarm.TaskStateShutdown, Timestamp: old}},
+		{Status: swarm.TaskStatus{State: swarm.TaskStateRunning, Timestamp: old}},
+	}}
+	if m.Satisfied(running) {
+		t.Error("should not pass filter. a task is running")
+	}
+	recent := &serviceResource{tasks: []swarm.Task{
+		{Status: swarm.TaskStatus{State: swarm.TaskStateFailed, Timestamp: time.Now()}},
+	}}
+	if m.Satisfied(recent) {
+		t.Error("should not pass filter. a task stopped just now")
+	}
+}
@@ -0,0 +1,196 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// sshCommand is the ssh client that tunnels the docker API. It does the
+// agent based authentication and the known_hosts checks of the operator.
+var sshCommand = "ssh"
+
+// isSSH tells if a docker uri is reached over ssh, e.g. ssh://ci@agent-1
+func isSSH(uri string) bool {
+	return strings.HasPrefix(uri, "ssh://")
+}
+
+// sshArgs returns the arguments of sshCommand that run
+// "docker system dial-stdio" on the host of uri. BatchMode keeps ssh from
+// prompting: hosts missing from known_hosts and keys missing from the agent
+// fail instead of blocking a daemon.
+func sshArgs(uri string) (args []string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh uri: %s", uri)
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid ssh uri: %s, path and query are not supported", uri)
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("invalid ssh uri: %s, passwords are not supported, use the ssh agent", uri)
+	}
+	args = []string{"-o", "BatchMode=yes"}
+	if u.User != nil && u.User.Username() != "" {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	return append(args, "--", u.Hostname(), "docker", "system", "dial-stdio"), nil
+}
+
+// newSSHClient creates a client whose connections are ssh processes. The
+// endpoint of the client is only used in the URLs of requests.
+func newSSHClient(uri, version string) (*docker.Client, error) {
+	args, err := sshArgs(uri)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := docker.NewVersionedClient("http://docker", version)
+	if err != nil {
+		return nil, err
+	}
+	dialer := sshDialer(args)
+	cli.Dialer = dialer
+	cli.HTTPClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+		IdleConnTimeout: 90 * time.Second,
+	}}
+	return cli, nil
+}
+
+// sshDialer dials by starting sshCommand with its arguments
+type sshDialer []string
+
+func (d sshDialer) Dial(network, address string) (net.Conn, error) {
+	c, err := dialCommand(sshCommand, d...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr lockedBuffer
+
+	// waited is closed once the command exited, and err is why it did
+	waited chan struct{}
+	err    error
+	close  sync.Once
+}
+
+// dialCommand starts a command and connects to its stdin and stdout
+func dialCommand(name string, args ...string) (c *commandConn, err error) {
+	c = &commandConn{cmd: exec.Command(name, args...), waited: make(chan struct{})}
+	c.cmd.Stderr = &c.stderr
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	// Wait closes the pipes of StdoutPipe before all reads are done, an
+	// io.Pipe is closed after them, with the failure of the command if any
+	stdout, w := io.Pipe()
+	c.stdout, c.cmd.Stdout = stdout, w
+	if err = c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %s", name, err)
+	}
+	go func() {
+		if c.err = c.cmd.Wait(); c.err != nil {
+			w.CloseWithError(c.failure())
+		} else {
+			w.Close()
+		}
+		close(c.waited)
+	}()
+	return c, nil
+}
+
+// Read returns the stderr of the command as the error if it failed, e.g.
+// "Host key verification failed"
+func (c *commandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	n, err := c.stdin.Write(p)
+	if err != nil {
+		select {
+		case <-c.waited:
+			if c.err != nil {
+				return n, c.failure()
+			}
+		default:
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) failure() error {
+	msg := strings.TrimSpace(c.stderr.String())
+	if msg == "" {
+		return fmt.Errorf("%s: %s", c.cmd.Path, c.err)
+	}
+	return fmt.Errorf("%s: %s", c.cmd.Path, msg)
+}
+
+// Close closes stdin and stops the command
+func (c *commandConn) Close() error {
+	c.close.Do(func() {
+		c.stdin.Close()
+		c.stdout.Close()
+		select {
+		case <-c.waited:
+		case <-time.After(time.Second):
+			c.cmd.Process.Kill()
+			<-c.waited
+		}
+	})
+	return nil
+}
+
+// the command has no address nor deadlines, http.Transport does not need
+// them
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
+
+// lockedBuffer is a buffer safe to read while the command writes to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
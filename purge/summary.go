@@ -0,0 +1,85 @@
+package purge
+
+import (
+	"fmt"
+)
+
+// Summary stores what a purge did to one type of resource
+type Summary struct {
+	// Host is the docker host of the purge when several hosts are purged
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+
+	Resource string `json:"resource" yaml:"resource"`
+
+	// Removed counts the removed resources, or the ones that would be
+	// removed in a dry run
+	Removed int `json:"removed" yaml:"removed"`
+
+	// Untagged counts the tags removed one at a time from images with
+	// several tags
+	Untagged int `json:"untagged" yaml:"untagged"`
+
+	// Skipped counts the resources that are kept
+	Skipped int `json:"skipped" yaml:"skipped"`
+
+	// Failed counts the resources that the daemon refused to remove
+	Failed int `json:"failed" yaml:"failed"`
+
+	// Reclaimed is the disk space in Bytes given back by the removed resources
+	Reclaimed int64 `json:"reclaimed" yaml:"reclaimed"`
+
+	// Shared is the size of image layers that the removed images share with
+	// other images. It is only given back once no image uses the layers.
+	Shared int64 `json:"shared" yaml:"shared"`
+}
+
+// totalSummary adds up summaries of all resource types
+func totalSummary(summaries ...Summary) Summary {
+	total := Summary{Resource: "total"}
+	for _, s := range summaries {
+		total.add(s)
+	}
+	return total
+}
+
+// hostTotals adds up summaries of each host, in the order of hosts. It
+// returns nil if summaries have no host.
+func hostTotals(summaries ...Summary) (totals []Summary) {
+	index := make(map[string]int)
+	for _, s := range summaries {
+		if s.Host == "" {
+			continue
+		}
+		i, ok := index[s.Host]
+		if !ok {
+			i = len(totals)
+			index[s.Host] = i
+			totals = append(totals, Summary{Host: s.Host, Resource: "total"})
+		}
+		totals[i].add(s)
+	}
+	return
+}
+
+// add adds the counts and sizes of o, keeping the resource of s
+func (s *Summary) add(o Summary) {
+	s.Removed += o.Removed
+	s.Untagged += o.Untagged
+	s.Skipped += o.Skipped
+	s.Failed += o.Failed
+	s.Reclaimed += o.Reclaimed
+	s.Shared += o.Shared
+}
+
+// HumanSize formats a size in Bytes with the units used by sizePtn
+func HumanSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
@@ -0,0 +1,17 @@
+package purge
+
+import "testing"
+
+func TestHumanSize(t *testing.T) {
+	cases := map[int64]string{
+		512:               "512B",
+		2048:              "2.00K",
+		500 * 1024 * 1024: "500.00M",
+		3 << 30:           "3.00G",
+	}
+	for size, expected := range cases {
+		if actual := HumanSize(size); actual != expected {
+			t.Errorf("human size error: %s, expected: %s", actual, expected)
+		}
+	}
+}
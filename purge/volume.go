@@ -0,0 +1,160 @@
+package purge
+
+import (
+	"context"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/spf13/cobra"
+)
+
+var cmdVol = &cobra.Command{
+	Use:   "volume",
+	Short: "Purge dangling and stale volumes",
+	Long:  "Purge dangling and stale volumes",
+	RunE:  RunCmdVolume,
+}
+
+// volumeFields are the fields of volumes that filters can compare
+var volumeFields = map[string]Field{
+	"created":  {Kind: TimeKind},
+	"name":     {Kind: StringKind},
+	"driver":   {Kind: StringKind},
+	"dangling": {Kind: BoolKind},
+}
+
+// volumeResource adapts a volume to Resource. used tells if any container
+// mounts the volume, size is its disk usage in Bytes.
+type volumeResource struct {
+	docker.Volume
+	used bool
+	size int64
+}
+
+// volumeUsage is the disk usage of a volume, -1 if the daemon did not
+// compute it. go-dockerclient does not decode the disk usage of volumes.
+type volumeUsage struct {
+	Name      string
+	UsageData struct {
+		Size int64
+	}
+}
+
+func (v *volumeResource) StringField(f string) []string {
+	switch f {
+	case "name":
+		return []string{v.Name}
+	case "driver":
+		return []string{v.Driver}
+	}
+	return nil
+}
+
+// IntField returns the created time only if the driver of the volume
+// reports it
+func (v *volumeResource) IntField(f string) (int64, bool) {
+	switch f {
+	case "created":
+		return v.CreatedAt.Unix(), !v.CreatedAt.IsZero()
+	case "dangling":
+		if v.used {
+			return 0, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func (v *volumeResource) Labels() map[string]string    { return v.Volume.Labels }
+func (v *volumeResource) ID() string                   { return v.Name }
+func (v *volumeResource) Names() []string              { return nil }
+func (v *volumeResource) Size() (unique, shared int64) { return v.size, 0 }
+func (v *volumeResource) Created() time.Time           { return v.CreatedAt }
+
+func (v *volumeResource) Status() string {
+	if v.used {
+		return "in use"
+	}
+	return "dangling"
+}
+
+// volumePurger purges volumes
+type volumePurger struct{}
+
+func newVolumePurger() Purger {
+	return volumePurger{}
+}
+
+func (volumePurger) Type() string             { return "volume" }
+func (volumePurger) Fields() map[string]Field { return volumeFields }
+
+// List returns volumes with whether any container mounts them, which the
+// "dangling" filter needs
+func (volumePurger) List(ctx context.Context, cli *docker.Client) (resources []Resource, err error) {
+	containers, err := cli.ListContainers(docker.ListContainersOptions{All: true, Context: ctx})
+	if err != nil {
+		return
+	}
+	used := make(map[string]bool)
+	for _, ctn := range containers {
+		for _, mount := range ctn.Mounts {
+			if mount.Name != "" {
+				used[mount.Name] = true
+			}
+		}
+	}
+	volumes, err := cli.ListVolumes(docker.ListVolumesOptions{Context: ctx})
+	if err != nil {
+		return
+	}
+	for _, vol := range volumes {
+		resources = append(resources, &volumeResource{vol, used[vol.Name], 0})
+	}
+	return
+}
+
+// Prepare sets the size of volumes from the disk usage of the daemon, which
+// the volume list does not carry
+func (volumePurger) Prepare(ctx context.Context, cli *docker.Client, resources []Resource, m *Matcher) error {
+	var usage struct {
+		Volumes []volumeUsage
+	}
+	if err := getJSON(ctx, cli, "/system/df", &usage); err != nil {
+		return err
+	}
+	sizes := make(map[string]int64, len(usage.Volumes))
+	for _, vol := range usage.Volumes {
+		if vol.UsageData.Size > 0 {
+			sizes[vol.Name] = vol.UsageData.Size
+		}
+	}
+	for _, r := range resources {
+		v := r.(*volumeResource)
+		v.size = sizes[v.Name]
+	}
+	return nil
+}
+
+func (volumePurger) Remove(ctx context.Context, cli *docker.Client, r Resource) error {
+	return cli.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{Context: ctx, Name: r.ID()})
+}
+
+func RunCmdVolume(cmd *cobra.Command, args []string) error {
+	var filters []Filter
+	for _, f := range filter {
+		reporter.Filter(f)
+		parsed, err := parseFilter(f)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, parsed)
+	}
+	return purgeHosts(cmd.Context(), func(ctx context.Context) ([]Summary, error) {
+		return single(RemoveVolumes(ctx, filters...))
+	})
+}
+
+// RemoveVolumes purges volumes with filters, see volumePurger
+func RemoveVolumes(ctx context.Context, filters ...Filter) (Summary, error) {
+	return Purge(ctx, newVolumePurger(), filters...)
+}
@@ -0,0 +1,107 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestVolumeCreatedFilter(t *testing.T) {
+	f := Filter{"created>10d", "created", GT, "10d"}
+	m, err := NewMatcher(volumeFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	vol := docker.Volume{CreatedAt: yesterday}
+	if m.Satisfied(&volumeResource{Volume: vol}) {
+		t.Errorf("filter the wrong result. created 1d ago")
+	}
+	vol.CreatedAt = yesterday.AddDate(0, -1, 0)
+	if !m.Satisfied(&volumeResource{Volume: vol}) {
+		t.Errorf("wrong filter result: created: 1m1d ago")
+	}
+	vol.CreatedAt = time.Time{}
+	if m.Satisfied(&volumeResource{Volume: vol}) {
+		t.Errorf("wrong filter result: created time unknown")
+	}
+}
+
+func TestVolumeDanglingFilter(t *testing.T) {
+	inUse := &volumeResource{docker.Volume{Name: "db-data"}, true, 0}
+	orphan := &volumeResource{docker.Volume{Name: "orphan"}, false, 0}
+	f := Filter{"dangling=true", "dangling", EQ, "true"}
+	m, err := NewMatcher(volumeFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if m.Satisfied(inUse) {
+		t.Error("should not pass filter. volume is in use")
+	}
+	if !m.Satisfied(orphan) {
+		t.Error("should pass filter. volume is not in use")
+	}
+
+	f = Filter{"dangling!=true", "dangling", NE, "true"}
+	m, err = NewMatcher(volumeFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if !m.Satisfied(inUse) {
+		t.Error("should pass filter. volume is in use")
+	}
+	if _, err = NewMatcher(volumeFields, Filter{"dangling>true", "dangling", GT, "true"}); err == nil {
+		t.Error("should not support > for dangling")
+	}
+}
+
+func TestVolumeDriverFilter(t *testing.T) {
+	f := Filter{"driver=local", "driver", EQ, "local"}
+	m, err := NewMatcher(volumeFields, f)
+	if err != nil {
+		t.Error("error when creating matcher", err)
+	}
+	if !m.Satisfied(&volumeResource{Volume: docker.Volume{Driver: "local"}}) {
+		t.Errorf("should pass filter. filter: %s", f.Source)
+	}
+	if m.Satisfied(&volumeResource{Volume: docker.Volume{Driver: "nfs"}}) {
+		t.Errorf("should not pass filter. filter: %s", f.Source)
+	}
+}
+
+func TestRemoveVolumesReclaimed(t *testing.T) {
+	defer func(c *docker.Client, d bool, r Reporter, tls *dockerTLS) {
+		client, dryRun, reporter, clientTLS = c, d, r, tls
+	}(client, dryRun, reporter, clientTLS)
+	dryRun, reporter, clientTLS = true, &recordBuffer{}, nil
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			fmt.Fprint(w, `[{"Id":"c1","Mounts":[{"Name":"db-data"}]}]`)
+		case "/volumes":
+			fmt.Fprint(w, `{"Volumes":[{"Name":"db-data"},{"Name":"orphan"},{"Name":"unknown"}]}`)
+		case "/system/df":
+			fmt.Fprint(w, `{"Volumes":[{"Name":"db-data","UsageData":{"Size":4096}},`+
+				`{"Name":"orphan","UsageData":{"Size":2048}},{"Name":"unknown","UsageData":{"Size":-1}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	var err error
+	if client, err = newClient(srv.URL, ""); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := RemoveVolumes(context.Background(), Filter{"dangling=true", "dangling", EQ, "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Removed != 2 || summary.Reclaimed != 2048 {
+		t.Errorf("wrong summary: %+v", summary)
+	}
+}